@@ -0,0 +1,250 @@
+// admin package implement an HTTP server exposing operational
+// endpoints:
+//     GET  /config  Return the configuration efr is running with
+//     POST /reload  Reload configuration file and apply reloadable settings
+//     GET  /debug/vars  Counters published using expvar
+//     GET  /graph/{id}  Follow relationships of a subscriber, see HandleGraph
+//     GET  /deadletter  Rejected events, see HandleDeadLetter
+// Other components can register additional endpoints using Handle.
+// When Token is set every request must carry it as an
+// "Authorization: Bearer" header.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andreadipersio/efr/config"
+	"github.com/andreadipersio/efr/event/deadletter"
+	"github.com/andreadipersio/efr/netutil"
+)
+
+const (
+	// Largest payload accepted when re-injecting a dead letter
+	maxPayloadSize = 64 * 1024
+
+	// Time allowed to read a request and to write its response
+	readTimeout  = 10 * time.Second
+	writeTimeout = 30 * time.Second
+)
+
+type Server struct {
+	Addr string
+
+	// Bearer token required on every request, empty to accept
+	// any request
+	Token string
+
+	Reloader *config.Reloader
+
+	mux *http.ServeMux
+}
+
+// Handle register handler for pattern on the admin server
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// GraphFunc return follow relationships of subscriberID,
+// encoded as JSON by the /graph endpoint
+type GraphFunc func(subscriberID string) interface{}
+
+// HandleGraph serve GET /graph/{id} using graph
+func (s *Server) HandleGraph(graph GraphFunc) {
+	s.mux.HandleFunc("/graph/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		subscriberID := strings.TrimPrefix(r.URL.Path, "/graph/")
+
+		if subscriberID == "" || strings.Contains(subscriberID, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, graph(subscriberID))
+	})
+}
+
+// ReinjectFunc send payload of a rejected event to be dispatched.
+// Source is the EventSource which sent payload, empty when the
+// payload has been replaced through the admin server.
+type ReinjectFunc func(source, payload string) error
+
+// HandleDeadLetter serve entries of sink:
+//     GET    /deadletter       List entries kept in memory
+//     POST   /deadletter/{id}  Re-inject the entry using reinject, the request
+//                              body, when not empty, replace its payload
+//                              and source
+//     DELETE /deadletter/{id}  Drop the entry
+// Re-injected and dropped entries are removed from memory.
+func (s *Server) HandleDeadLetter(sink *deadletter.Sink, reinject ReinjectFunc) {
+	s.mux.HandleFunc("/deadletter", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		WriteJSON(w, http.StatusOK, sink.Entries())
+	})
+
+	s.mux.HandleFunc("/deadletter/", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/deadletter/"))
+
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		entry, exist := sink.Get(id)
+
+		if !exist {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "POST":
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+
+			if err != nil {
+				WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+
+			payload, source := strings.TrimSpace(string(body)), ""
+
+			if payload == "" {
+				payload, source = entry.Payload, entry.Source
+			}
+
+			if err := reinject(source, payload); err != nil {
+				WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+
+			sink.Remove(id)
+
+			log.Printf("  = Dead letter %v re-injected as %v", id, payload)
+
+			WriteJSON(w, http.StatusOK, map[string]string{"payload": payload})
+		case "DELETE":
+			sink.Remove(id)
+
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// ServeHTTP make Server an http.Handler, mainly to ease testing
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		WriteJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized report whenever r carry Token, if any
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(auth, "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, s.Reloader.Current())
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := s.Reloader.Reload()
+
+	if err != nil {
+		log.Printf("*** Configuration reload failed: %v", err)
+		WriteJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Printf("  = Configuration reloaded: applied %v, restart required %v",
+		result.Applied, result.RestartRequired)
+
+	WriteJSON(w, http.StatusOK, result)
+}
+
+// Listen for admin requests on Addr
+func (s *Server) Listen() {
+	ln, err := netutil.Listen(s.Addr, nil)
+
+	if err != nil {
+		log.Fatalf("*** Cannot start Admin server: %v", err)
+	}
+
+	log.Printf("=== Admin server listening to %v", s.Addr)
+
+	server := &http.Server{
+		Handler:      s,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+	}
+
+	if err := server.Serve(ln); err != nil {
+		log.Fatalf("*** Admin server stopped: %v", err)
+	}
+}
+
+// WriteJSON write v as the JSON body of a response with status code
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Cannot encode admin response: %v", err)
+	}
+}
+
+func New(addr string, reloader *config.Reloader) *Server {
+	s := &Server{
+		Addr:     addr,
+		Reloader: reloader,
+		mux:      http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/config", s.handleConfig)
+	s.mux.HandleFunc("/reload", s.handleReload)
+	s.mux.Handle("/debug/vars", expvar.Handler())
+
+	return s
+}
@@ -0,0 +1,203 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andreadipersio/efr/admin"
+	"github.com/andreadipersio/efr/config"
+	"github.com/andreadipersio/efr/event/deadletter"
+)
+
+func newTestServer(t *testing.T) *admin.Server {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	c, err := config.Load([]string{}, noEnv)
+
+	if err != nil {
+		t.Fatalf("Cannot load config: %v", err)
+	}
+
+	reloader := config.NewReloader(c, []string{}, noEnv, func(*config.Config) {})
+
+	return admin.New("", reloader)
+}
+
+// TestReload prove that /reload report reload results
+// and only accept POST requests
+func TestReload(t *testing.T) {
+	s := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/reload", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status %v, got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/reload", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body)
+	}
+
+	result := &config.ReloadResult{}
+
+	if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+		t.Fatalf("Cannot decode reload result: %v", err)
+	}
+
+	if len(result.Applied) != 0 || len(result.RestartRequired) != 0 {
+		t.Fatalf("Expected no changes, got %+v", result)
+	}
+}
+
+// TestToken prove that requests without the token are refused
+func TestToken(t *testing.T) {
+	s := newTestServer(t)
+	s.Token = "secret"
+
+	for auth, code := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/config", nil)
+		r.Header.Set("Authorization", auth)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != code {
+			t.Fatalf("Authorization %q: expected status %v, got %v", auth, code, w.Code)
+		}
+	}
+}
+
+// TestConfig prove that /config return the running configuration
+func TestConfig(t *testing.T) {
+	s := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/config", nil))
+
+	c := &config.Config{}
+
+	if err := json.NewDecoder(w.Body).Decode(c); err != nil {
+		t.Fatalf("Cannot decode config: %v", err)
+	}
+
+	if c.Resequencer.Type != "stream" {
+		t.Fatalf("Expected default resequencer type, got %+v", c)
+	}
+}
+
+// TestGraph prove that /graph/{id} encode follow relationships
+// returned by the registered GraphFunc
+func TestGraph(t *testing.T) {
+	s := newTestServer(t)
+
+	s.HandleGraph(func(subscriberID string) interface{} {
+		return map[string]string{"subscriberID": subscriberID}
+	})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/graph/42", nil))
+
+	graph := map[string]string{}
+
+	if err := json.NewDecoder(w.Body).Decode(&graph); err != nil {
+		t.Fatalf("Cannot decode graph: %v", err)
+	}
+
+	if graph["subscriberID"] != "42" {
+		t.Fatalf("Expected graph of 42, got %+v", graph)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/graph/", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+// TestDeadLetter prove that dead letters can be listed,
+// re-injected with a fixed payload and dropped
+func TestDeadLetter(t *testing.T) {
+	s := newTestServer(t)
+	sink := deadletter.New(10, nil)
+
+	reinjected := []string{}
+
+	s.HandleDeadLetter(sink, func(source, payload string) error {
+		if payload == "bogus" {
+			return fmt.Errorf("Cannot parse %v", payload)
+		}
+
+		// replaced payloads have no source
+		if source != "" {
+			return fmt.Errorf("Source %v is not allowed", source)
+		}
+
+		reinjected = append(reinjected, payload)
+
+		return nil
+	})
+
+	sink.Add(deadletter.LISTENER_STAGE, "127.0.0.1", "bogus", fmt.Errorf("invalid"))
+	sink.Add(deadletter.DISPATCHER_STAGE, "", "2|F|1", fmt.Errorf("no recipient"))
+	sink.Add(deadletter.LISTENER_STAGE, "producer", "3|S|1", fmt.Errorf("not allowed"))
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/deadletter", nil))
+
+	entries := []*deadletter.Entry{}
+
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil || len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %+v (%v)", entries, err)
+	}
+
+	// unchanged payloads still fail, keeping their source
+	for _, id := range []string{"1", "3"} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("POST", "/deadletter/"+id, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Entry %v: expected status %v, got %v", id, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/deadletter/1", strings.NewReader("1|B\n")))
+
+	if w.Code != http.StatusOK || len(reinjected) != 1 || reinjected[0] != "1|B" {
+		t.Fatalf("Expected fixed payload to be re-injected, got %v %v", w.Code, reinjected)
+	}
+
+	for _, id := range []string{"2", "3"} {
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("DELETE", "/deadletter/"+id, nil))
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Entry %v: expected status %v, got %v", id, http.StatusNoContent, w.Code)
+		}
+	}
+
+	if entries := sink.Entries(); len(entries) != 0 {
+		t.Fatalf("Re-injected and dropped entries should be removed, got %+v", entries)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/deadletter/1", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,559 @@
+// config package implement loading of efr settings.
+// Settings are read, in increasing order of precedence, from
+//   - built-in defaults
+//   - a JSON or TOML config file (-config flag or EFR_CONFIG)
+//   - environment variables (EFR_ followed by the flag name in
+//     upper snake case, e.g. EFR_RESEQUENCER_TYPE)
+//   - command line flags
+// Once loaded, the configuration is validated so that meaningless
+// values are rejected instead of being silently replaced by defaults.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/event/deadletter"
+	"github.com/andreadipersio/efr/event/dispatcher"
+	"github.com/andreadipersio/efr/event/listener"
+	"github.com/andreadipersio/efr/example"
+	"github.com/andreadipersio/efr/netutil"
+	"github.com/andreadipersio/efr/tlsutil"
+)
+
+const envPrefix = "EFR_"
+
+type ListenerConfig struct {
+	// EventSource connection addresses, see netutil.ParseAddr
+	Addrs netutil.AddrList `json:"addrs"`
+
+	// Unix domain socket permissions
+	Socket *netutil.SocketOptions `json:"socket"`
+
+	// Use ClientCAFile to only accept EventSources presenting
+	// a trusted certificate
+	TLS tlsutil.Config `json:"tls"`
+
+	// Address of the HTTP server accepting events on /events,
+	// empty to disable it, requires server sequencing
+	HTTPAddr string `json:"httpAddr"`
+
+	// "producer" when EventSources stamp sequence numbers, which are
+	// then resequenced, "server" to number events on arrival
+	Sequencing string `json:"sequencing"`
+
+	// File persisting the sequence counter, required by server sequencing
+	SequenceFile string `json:"sequenceFile"`
+
+	// Sequence numbers reserved on disk at once by server sequencing
+	SequenceBlockSize int `json:"sequenceBlockSize"`
+
+	// Restrict events each EventSource can publish,
+	// can only be set in config file
+	Policy *listener.Policy `json:"policy"`
+
+	// Events violating Policy are appended to this file
+	// when policy action is 'quarantine'
+	QuarantineFile string `json:"quarantineFile"`
+}
+
+type SubscriptionConfig struct {
+	// Clients will subscribe using these addresses, see netutil.ParseAddr
+	Addrs netutil.AddrList `json:"addrs"`
+
+	// Unix domain socket permissions
+	Socket *netutil.SocketOptions `json:"socket"`
+
+	TLS tlsutil.Config `json:"tls"`
+
+	// Address of the HTTP server accepting WebSocket and
+	// Server-Sent Events subscriptions, empty to disable it
+	HTTPAddr string `json:"httpAddr"`
+
+	// File containing the secret used to verify client tokens.
+	// When empty clients are not authenticated.
+	AuthSecretFile string `json:"authSecretFile"`
+}
+
+type DispatcherConfig struct {
+	// Number of resequenced events that can be queued
+	// before the listener blocks waiting for the dispatcher.
+	QueueSize int `json:"queueSize"`
+
+	// Who is notified of each event type, replacing built-in rules
+	// for the types listed, can only be set in config file
+	Delivery event.DeliveryRules `json:"delivery"`
+
+	// How far from the sender "network" delivery reach,
+	// 2 reach followers of followers
+	NetworkHops int `json:"networkHops"`
+
+	// Directory where subscribers and follow relationships are
+	// saved, so that they survive restarts, empty to keep them
+	// in memory only
+	StoreDir string `json:"storeDir"`
+
+	// Disconnected subscribers kept in memory when StoreDir is set,
+	// others are loaded from it when needed
+	CacheSize int `json:"cacheSize"`
+
+	Eviction EvictionConfig `json:"eviction"`
+}
+
+// EvictionConfig define when idle disconnected subscribers are
+// removed from memory, see dispatcher.EvictionPolicy
+type EvictionConfig struct {
+	// How often idle subscribers are looked for
+	Interval Duration `json:"interval"`
+
+	// Subscribers without followers, follow requests, blocks or mutes
+	// idle for longer are evicted, 0 to keep them
+	OrphanTTL Duration `json:"orphanTTL"`
+
+	// Other subscribers idle for longer are saved to StoreDir
+	// and evicted, 0 to keep them
+	IdleTTL Duration `json:"idleTTL"`
+
+	// Directory where evicted subscribers are saved, unused
+	// when the dispatcher has its own StoreDir.
+	// It is cleared on startup: the directory is in memory, so
+	// subscribers saved by a previous run would be stale.
+	StoreDir string `json:"storeDir"`
+}
+
+// Enabled report whenever any subscriber can be evicted
+func (c *EvictionConfig) Enabled() bool {
+	return c.OrphanTTL > 0 || c.IdleTTL > 0
+}
+
+type DeadLetterConfig struct {
+	// Number of rejected events kept in memory
+	Size int `json:"size"`
+
+	// Rejected events are also appended to this file, as JSON lines,
+	// when not empty
+	File string `json:"file"`
+}
+
+type AdminConfig struct {
+	// Address of the admin server, empty to disable it
+	Addr string `json:"addr"`
+
+	// File containing the token requests must carry as an
+	// "Authorization: Bearer" header. When empty the admin server
+	// can only listen on loopback addresses or Unix domain sockets.
+	TokenFile string `json:"tokenFile"`
+}
+
+type Config struct {
+	// Max number of OS Thread that can run simultaneously
+	MaxProcs int `json:"maxProcs"`
+
+	Listener     ListenerConfig             `json:"listener"`
+	Resequencer  listener.ResequencerConfig `json:"resequencer"`
+	Subscription SubscriptionConfig         `json:"subscription"`
+	Dispatcher   DispatcherConfig           `json:"dispatcher"`
+	DeadLetter   DeadLetterConfig           `json:"deadLetter"`
+	Admin        AdminConfig                `json:"admin"`
+
+	// Path of the config file this value has been read from
+	Path string `json:"-"`
+}
+
+// Default return a Config value holding default settings
+func Default() *Config {
+	return &Config{
+		MaxProcs: 1,
+		Listener: ListenerConfig{
+			Addrs:             netutil.AddrList{":9090"},
+			Sequencing:        listener.PRODUCER_SEQUENCING,
+			SequenceBlockSize: listener.DefaultSequenceBlockSize,
+		},
+		Resequencer: listener.ResequencerConfig{
+			Type:          "stream",
+			Capacity:      100,
+			SequenceIndex: 0,
+		},
+		Subscription: SubscriptionConfig{
+			Addrs: netutil.AddrList{":9099"},
+		},
+		Dispatcher: DispatcherConfig{
+			NetworkHops: example.DefaultNetworkHops,
+			CacheSize:   dispatcher.DefaultCacheSize,
+			Eviction: EvictionConfig{
+				Interval: Duration(time.Minute),
+			},
+		},
+		DeadLetter: DeadLetterConfig{
+			Size: deadletter.DefaultSize,
+		},
+	}
+}
+
+// FlagSet return a flag set whose flags are bound to c fields.
+func (c *Config) FlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("efr", flag.ContinueOnError)
+
+	fs.StringVar(&c.Path, "config", c.Path, "Path of a JSON or TOML config file")
+
+	fs.IntVar(&c.MaxProcs, "maxProcs", c.MaxProcs,
+		"Max number of OS Thread that can run simultaneously")
+
+	fs.StringVar(&c.Resequencer.Type, "resequencerType", c.Resequencer.Type,
+		"Resequencer type, can be 'batch' or 'stream'")
+
+	fs.IntVar(&c.Resequencer.Capacity, "resequencerCapacity", c.Resequencer.Capacity,
+		"Resequencer capacity.")
+
+	fs.IntVar(&c.Resequencer.SequenceIndex, "sequenceIndex", c.Resequencer.SequenceIndex,
+		"Last know sequence number. Stream resequencer "+
+			"will start resequencing from sequenceIndex+1")
+
+	fs.Var(&c.Listener.Addrs, "eventSourceAddr",
+		"Comma separated EventSource connection addresses, "+
+			"host:port, [ipv6]:port or unix:///path/to/socket")
+
+	fs.Var(&c.Subscription.Addrs, "clientAddr",
+		"Comma separated addresses clients will subscribe using, "+
+			"host:port, [ipv6]:port or unix:///path/to/socket")
+
+	fs.StringVar(&c.Listener.HTTPAddr, "eventSourceHTTPAddr", c.Listener.HTTPAddr,
+		"Address of the HTTP server accepting events on POST /events, requires server sequencing, empty to disable")
+
+	fs.StringVar(&c.Listener.Sequencing, "eventSourceSequencing", c.Listener.Sequencing,
+		"Who assign sequence numbers, 'producer' (events are resequenced) "+
+			"or 'server' (events are numbered on arrival)")
+
+	fs.StringVar(&c.Listener.SequenceFile, "sequenceFile", c.Listener.SequenceFile,
+		"File persisting the sequence counter when sequencing is 'server'")
+
+	fs.IntVar(&c.Listener.SequenceBlockSize, "sequenceBlockSize", c.Listener.SequenceBlockSize,
+		"Sequence numbers reserved on disk at once when sequencing is 'server'")
+
+	fs.StringVar(&c.Listener.TLS.CertFile, "eventSourceTLSCert", c.Listener.TLS.CertFile,
+		"EventSource TLS certificate file, enable TLS on the EventSource port")
+
+	fs.StringVar(&c.Listener.TLS.KeyFile, "eventSourceTLSKey", c.Listener.TLS.KeyFile,
+		"EventSource TLS private key file")
+
+	fs.StringVar(&c.Listener.TLS.ClientCAFile, "eventSourceTLSClientCA", c.Listener.TLS.ClientCAFile,
+		"CA certificates file, when set EventSource must present a certificate signed by one of them")
+
+	fs.StringVar(&c.Subscription.TLS.CertFile, "clientTLSCert", c.Subscription.TLS.CertFile,
+		"Client TLS certificate file, enable TLS on the client port")
+
+	fs.StringVar(&c.Subscription.TLS.KeyFile, "clientTLSKey", c.Subscription.TLS.KeyFile,
+		"Client TLS private key file")
+
+	fs.StringVar(&c.Subscription.HTTPAddr, "clientHTTPAddr", c.Subscription.HTTPAddr,
+		"Address of the HTTP server accepting WebSocket (/ws) and SSE (/subscribe/{id}) subscriptions, empty to disable")
+
+	fs.StringVar(&c.Subscription.AuthSecretFile, "clientAuthSecretFile", c.Subscription.AuthSecretFile,
+		"File containing the HMAC secret used to verify client tokens, empty to disable authentication")
+
+	fs.IntVar(&c.Dispatcher.QueueSize, "dispatcherQueueSize", c.Dispatcher.QueueSize,
+		"Number of resequenced events buffered before the dispatcher")
+
+	fs.IntVar(&c.Dispatcher.NetworkHops, "networkHops", c.Dispatcher.NetworkHops,
+		"How far from the sender events with network delivery are propagated")
+
+	fs.StringVar(&c.Dispatcher.StoreDir, "dispatcherStoreDir", c.Dispatcher.StoreDir,
+		"Directory where subscribers and follow relationships are saved across restarts, empty to keep them in memory")
+
+	fs.IntVar(&c.Dispatcher.CacheSize, "dispatcherCacheSize", c.Dispatcher.CacheSize,
+		"Disconnected subscribers kept in memory when dispatcherStoreDir is set")
+
+	fs.Var(&c.Dispatcher.Eviction.Interval, "evictionInterval",
+		"How often idle disconnected subscribers are looked for")
+
+	fs.Var(&c.Dispatcher.Eviction.OrphanTTL, "evictionOrphanTTL",
+		"Evict disconnected subscribers without followers, requests, blocks or mutes idle for longer, 0 to disable")
+
+	fs.Var(&c.Dispatcher.Eviction.IdleTTL, "evictionIdleTTL",
+		"Save to evictionStoreDir (or dispatcherStoreDir) and evict other disconnected subscribers idle for longer, 0 to disable")
+
+	fs.StringVar(&c.Dispatcher.Eviction.StoreDir, "evictionStoreDir", c.Dispatcher.Eviction.StoreDir,
+		"Directory where evicted subscribers are saved, until they are needed again, cleared on startup")
+
+	fs.IntVar(&c.DeadLetter.Size, "deadLetterSize", c.DeadLetter.Size,
+		"Number of rejected events kept in memory, available on the admin server")
+
+	fs.StringVar(&c.DeadLetter.File, "deadLetterFile", c.DeadLetter.File,
+		"File rejected events are appended to, empty to keep them only in memory")
+
+	fs.StringVar(&c.Admin.Addr, "adminAddr", c.Admin.Addr,
+		"Admin server address (e.g. localhost:9100), empty to disable")
+
+	fs.StringVar(&c.Admin.TokenFile, "adminTokenFile", c.Admin.TokenFile,
+		"File containing the bearer token required by the admin server, needed unless it listen on loopback or a Unix socket")
+
+	return fs
+}
+
+// ReadFile decode the config file at path on top of c, in JSON or,
+// for .toml files, TOML. Unknown fields are reported as errors.
+func (c *Config) ReadFile(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	if ext != ".json" && ext != ".toml" {
+		return fmt.Errorf("Unsupported config file format %q, only .json and .toml are supported", ext)
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return fmt.Errorf("Cannot open config file: %v", err)
+	}
+
+	// TOML is decoded as JSON would be, so that settings are
+	// read the same way in both formats
+	if ext == ".toml" {
+		values, err := decodeTOML(data)
+
+		if err != nil {
+			return fmt.Errorf("Cannot parse config file %v: %v", path, err)
+		}
+
+		if data, err = json.Marshal(values); err != nil {
+			return fmt.Errorf("Cannot parse config file %v: %v", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("Cannot parse config file %v: %v", path, err)
+	}
+
+	c.Path = path
+
+	return nil
+}
+
+// Validate return an error describing every invalid setting in c
+func (c *Config) Validate() error {
+	errs := []string{}
+
+	if c.MaxProcs < 1 {
+		errs = append(errs, fmt.Sprintf("maxProcs should be greater than 0, got %v", c.MaxProcs))
+	}
+
+	if err := c.Resequencer.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	switch c.Listener.Sequencing {
+	case listener.PRODUCER_SEQUENCING:
+	case listener.SERVER_SEQUENCING:
+		if c.Listener.SequenceFile == "" {
+			errs = append(errs, "server sequencing requires a sequenceFile")
+		}
+
+		if c.Listener.SequenceBlockSize < 1 {
+			errs = append(errs, fmt.Sprintf("sequenceBlockSize should be greater than 0, got %v", c.Listener.SequenceBlockSize))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("eventSourceSequencing should be 'producer' or 'server', got %q", c.Listener.Sequencing))
+	}
+
+	// posted events cannot be resequenced
+	if c.Listener.HTTPAddr != "" && c.Listener.Sequencing != listener.SERVER_SEQUENCING {
+		errs = append(errs, "eventSourceHTTPAddr requires server sequencing")
+	}
+
+	// addresses by flag name, optional ones can be empty
+	addrs := []struct {
+		name     string
+		addrs    []string
+		optional bool
+	}{
+		{"eventSourceAddr", c.Listener.Addrs, false},
+		{"eventSourceHTTPAddr", []string{c.Listener.HTTPAddr}, true},
+		{"clientAddr", c.Subscription.Addrs, false},
+		{"clientHTTPAddr", []string{c.Subscription.HTTPAddr}, true},
+		{"adminAddr", []string{c.Admin.Addr}, true},
+	}
+
+	used := map[string]string{}
+
+	for _, a := range addrs {
+		if len(a.addrs) == 0 && !a.optional {
+			errs = append(errs, fmt.Sprintf("%v: at least one address is required", a.name))
+		}
+
+		for _, addr := range a.addrs {
+			if addr == "" && a.optional {
+				continue
+			}
+
+			network, address, err := netutil.ParseAddr(addr)
+
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", a.name, err))
+				continue
+			}
+
+			key := network + "://" + address
+
+			if other, exist := used[key]; exist {
+				errs = append(errs, fmt.Sprintf("%v and %v cannot use the same address (%v)", other, a.name, addr))
+			}
+
+			used[key] = a.name
+		}
+	}
+
+	// anyone reaching the admin server can reload and re-inject events
+	if a := c.Admin; a.Addr != "" && a.TokenFile == "" && !netutil.IsLocal(a.Addr) {
+		errs = append(errs, "adminAddr should be a loopback or unix socket address unless adminTokenFile is set")
+	}
+
+	for _, socket := range []*netutil.SocketOptions{c.Listener.Socket, c.Subscription.Socket} {
+		if socket == nil {
+			continue
+		}
+
+		if err := socket.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if err := c.Listener.TLS.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("eventSource TLS: %v", err))
+	}
+
+	if p := c.Listener.Policy; p != nil {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, err.Error())
+		} else if p.Action == listener.QUARANTINE_ACTION && c.Listener.QuarantineFile == "" {
+			errs = append(errs, "quarantine policy action requires a quarantineFile")
+		}
+	}
+
+	if err := c.Subscription.TLS.Validate(); err != nil {
+		errs = append(errs, fmt.Sprintf("client TLS: %v", err))
+	}
+
+	if c.Dispatcher.QueueSize < 0 {
+		errs = append(errs, fmt.Sprintf("dispatcherQueueSize cannot be negative, got %v", c.Dispatcher.QueueSize))
+	}
+
+	if c.Dispatcher.CacheSize < 1 {
+		errs = append(errs, fmt.Sprintf("dispatcherCacheSize should be at least 1, got %v", c.Dispatcher.CacheSize))
+	}
+
+	if c.Dispatcher.NetworkHops < 1 {
+		errs = append(errs, fmt.Sprintf("networkHops should be at least 1, got %v", c.Dispatcher.NetworkHops))
+	}
+
+	if e := c.Dispatcher.Eviction; e.Interval <= 0 || e.OrphanTTL < 0 || e.IdleTTL < 0 {
+		errs = append(errs, "evictionInterval should be positive and eviction TTLs cannot be negative")
+	} else if e.IdleTTL > 0 && e.StoreDir == "" && c.Dispatcher.StoreDir == "" {
+		errs = append(errs, "evictionIdleTTL require evictionStoreDir or dispatcherStoreDir")
+	}
+
+	if c.DeadLetter.Size < 1 {
+		errs = append(errs, fmt.Sprintf("deadLetterSize should be greater than 0, got %v", c.DeadLetter.Size))
+	}
+
+	if err := c.Dispatcher.Delivery.Validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Invalid configuration: %v", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// EnvName return the environment variable overriding the flag named name,
+// runs of capitals, such as acronyms, are a single word
+//     resequencerType -> EFR_RESEQUENCER_TYPE
+//     clientHTTPAddr  -> EFR_CLIENT_HTTP_ADDR
+func EnvName(name string) string {
+	runes := []rune(name)
+	env := []rune{}
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			// a new word start after a lower case letter, or with the
+			// last capital of a run followed by a lower case letter
+			afterLower := !unicode.IsUpper(runes[i-1])
+			endOfRun := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if afterLower || endOfRun {
+				env = append(env, '_')
+			}
+		}
+
+		env = append(env, unicode.ToUpper(r))
+	}
+
+	return envPrefix + string(env)
+}
+
+// Load return a validated Config built from defaults, config file,
+// environment (looked up using lookupEnv) and command line args.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	// First pass only tell us which config file to read
+	first := Default()
+
+	if v, ok := lookupEnv(EnvName("config")); ok {
+		first.Path = v
+	}
+
+	if err := first.FlagSet().Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+
+	if first.Path != "" {
+		if err := c.ReadFile(first.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	// errors and usage are already reported by the first pass
+	fs := c.FlagSet()
+	fs.SetOutput(io.Discard)
+
+	var envErr error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := lookupEnv(EnvName(f.Name))
+
+		if !ok || envErr != nil {
+			return
+		}
+
+		if err := fs.Set(f.Name, v); err != nil {
+			envErr = fmt.Errorf("Invalid value %q for %v: %v", v, EnvName(f.Name), err)
+		}
+	})
+
+	if envErr != nil {
+		return nil, envErr
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
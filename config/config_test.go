@@ -0,0 +1,351 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/andreadipersio/efr/config"
+)
+
+// writeConfigFile write content in a temporary config file
+// and return its path
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Cannot write config file: %v", err)
+	}
+
+	return path
+}
+
+// envFromMap return a lookupEnv function backed by env
+func envFromMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+// TestLoadPrecedence prove that flags override environment
+// which override config file which override defaults
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "efr.json", `{
+		"resequencer": {"type": "batch", "capacity": 10},
+		"listener": {"addrs": ":7000"},
+		"subscription": {"addrs": ["127.0.0.1:7001", "[::1]:7001"]}
+	}`)
+
+	env := envFromMap(map[string]string{
+		"EFR_RESEQUENCER_CAPACITY": "20",
+		"EFR_EVENT_SOURCE_ADDR":    ":8000",
+	})
+
+	c, err := config.Load([]string{"-config", path, "-eventSourceAddr", "localhost:8500,[::1]:8500"}, env)
+
+	if err != nil {
+		t.Fatalf("Cannot load config: %v", err)
+	}
+
+	// from defaults
+	if c.MaxProcs != 1 {
+		t.Fatalf("Expected maxProcs 1, got %v", c.MaxProcs)
+	}
+
+	// from config file
+	if c.Resequencer.Type != "batch" || c.Subscription.Addrs.String() != "127.0.0.1:7001,[::1]:7001" {
+		t.Fatalf("Config file values not applied: %+v", c)
+	}
+
+	// from env
+	if c.Resequencer.Capacity != 20 {
+		t.Fatalf("Expected capacity 20 from env, got %v", c.Resequencer.Capacity)
+	}
+
+	// from flags
+	if addrs := c.Listener.Addrs.String(); addrs != "localhost:8500,[::1]:8500" {
+		t.Fatalf("Expected event source addresses localhost:8500,[::1]:8500 from flags, got %v", addrs)
+	}
+}
+
+// TestValidate prove that nonsense values are rejected
+func TestValidate(t *testing.T) {
+	type testDataType struct {
+		args    []string
+		isValid bool
+	}
+
+	testData := []testDataType{
+		testDataType{[]string{}, true},
+		testDataType{[]string{"-resequencerType", "batch"}, true},
+
+		testDataType{[]string{"-resequencerCapacity", "0"}, false},
+		testDataType{[]string{"-resequencerType", "random"}, false},
+		testDataType{[]string{"-sequenceIndex", "-1"}, false},
+		testDataType{[]string{"-maxProcs", "0"}, false},
+		testDataType{[]string{"-clientAddr", "unix:///tmp/efr.sock"}, true},
+		testDataType{[]string{"-clientAddr", "127.0.0.1:9099,[::]:9099"}, true},
+		testDataType{[]string{"-clientAddr", "[fe80::1%eth0]:9099"}, true},
+
+		testDataType{[]string{"-clientAddr", "9099"}, false},
+		testDataType{[]string{"-clientAddr", ":9090"}, false},
+		testDataType{[]string{"-clientAddr", ""}, false},
+		testDataType{[]string{"-clientAddr", ":9099,:9099"}, false},
+		testDataType{[]string{"-clientAddr", "::1:9099"}, false},
+		testDataType{[]string{"-clientAddr", "[fe80::zz]:9099"}, false},
+		testDataType{[]string{"-clientAddr", "udp://:9099"}, false},
+		testDataType{[]string{"-adminAddr", "tcp://:9099"}, false},
+		testDataType{[]string{"-adminAddr", "localhost:9100"}, true},
+		testDataType{[]string{"-adminAddr", "unix:///tmp/efr-admin.sock"}, true},
+		testDataType{[]string{"-adminAddr", ":9100"}, false},
+		testDataType{[]string{"-adminAddr", ":9100", "-adminTokenFile", "/etc/efr/admin.token"}, true},
+		testDataType{[]string{"-dispatcherQueueSize", "-5"}, false},
+		testDataType{[]string{"-networkHops", "0"}, false},
+		testDataType{[]string{"-networkHops", "3"}, true},
+		testDataType{[]string{"-evictionOrphanTTL", "1h"}, true},
+		testDataType{[]string{"-evictionOrphanTTL", "forever"}, false},
+		testDataType{[]string{"-evictionInterval", "0s"}, false},
+		testDataType{[]string{"-evictionIdleTTL", "24h"}, false},
+		testDataType{[]string{"-evictionIdleTTL", "24h", "-evictionStoreDir", "/tmp/efr"}, true},
+		testDataType{[]string{"-evictionIdleTTL", "24h", "-dispatcherStoreDir", "/tmp/efr"}, true},
+		testDataType{[]string{"-dispatcherStoreDir", "/tmp/efr", "-dispatcherCacheSize", "100"}, true},
+		testDataType{[]string{"-dispatcherCacheSize", "0"}, false},
+		testDataType{[]string{"-eventSourceSequencing", "server", "-sequenceFile", "/tmp/efr.seq"}, true},
+		testDataType{[]string{"-eventSourceSequencing", "server"}, false},
+		testDataType{[]string{"-eventSourceSequencing", "server", "-sequenceFile", "/tmp/efr.seq", "-sequenceBlockSize", "0"}, false},
+		testDataType{[]string{"-eventSourceSequencing", "client"}, false},
+		testDataType{[]string{"-eventSourceHTTPAddr", ":9097"}, false},
+		testDataType{[]string{"-eventSourceHTTPAddr", ":9097", "-eventSourceSequencing", "server", "-sequenceFile", "/tmp/efr.seq"}, true},
+	}
+
+	for _, td := range testData {
+		_, err := config.Load(td.args, envFromMap(nil))
+
+		if td.isValid && err != nil {
+			t.Fatalf("Loading %v should succeed! Got error: %v", td.args, err)
+		}
+
+		if !td.isValid && err == nil {
+			t.Fatalf("Loading %v should fail!", td.args)
+		}
+	}
+}
+
+// TestReadFileErrors prove that unknown fields and unsupported
+// formats are reported
+func TestReadFileErrors(t *testing.T) {
+	unknownField := writeConfigFile(t, "efr.json", `{"resequencer": {"kind": "batch"}}`)
+
+	if err := config.Default().ReadFile(unknownField); err == nil {
+		t.Fatalf("Expected error for unknown field")
+	}
+
+	yaml := writeConfigFile(t, "efr.yaml", "maxProcs: 2\n")
+
+	if err := config.Default().ReadFile(yaml); err == nil ||
+		!strings.Contains(err.Error(), "Unsupported") {
+		t.Fatalf("Expected unsupported format error, got %v", err)
+	}
+
+	if err := config.Default().ReadFile(filepath.Join(os.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("Expected error for missing file")
+	}
+}
+
+// TestDeliveryRules prove that delivery rules are read from config file
+// and unknown targets rejected
+func TestDeliveryRules(t *testing.T) {
+	path := writeConfigFile(t, "efr.json", `{"dispatcher": {"delivery": {"U": ["sender", "recipient"]}}}`)
+
+	c, err := config.Load([]string{"-config", path}, envFromMap(nil))
+
+	if err != nil {
+		t.Fatalf("Cannot load config: %v", err)
+	}
+
+	if d := c.Dispatcher.Delivery["U"]; len(d) != 2 || !d.Has("sender") {
+		t.Fatalf("Unexpected delivery rule %v", d)
+	}
+
+	path = writeConfigFile(t, "efr.json", `{"dispatcher": {"delivery": {"U": ["everyone"]}}}`)
+
+	if _, err := config.Load([]string{"-config", path}, envFromMap(nil)); err == nil {
+		t.Fatalf("Unknown delivery target should be rejected")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	testData := map[string]string{
+		"resequencerType":        "EFR_RESEQUENCER_TYPE",
+		"eventSourceTLSCert":     "EFR_EVENT_SOURCE_TLS_CERT",
+		"eventSourceTLSClientCA": "EFR_EVENT_SOURCE_TLS_CLIENT_CA",
+		"clientHTTPAddr":         "EFR_CLIENT_HTTP_ADDR",
+		"evictionOrphanTTL":      "EFR_EVICTION_ORPHAN_TTL",
+	}
+
+	for flagName, expected := range testData {
+		if name := config.EnvName(flagName); name != expected {
+			t.Fatalf("Expected %v for %v, got %v", expected, flagName, name)
+		}
+	}
+}
+
+// TestReadTOML prove that TOML config files are read as the
+// equivalent JSON ones, and that syntax errors are reported
+func TestReadTOML(t *testing.T) {
+	fromJSON := config.Default()
+
+	err := fromJSON.ReadFile(writeConfigFile(t, "efr.json", `{
+		"maxProcs": 2,
+		"listener": {"addrs": ["127.0.0.1:9090", "[::1]:9090"]},
+		"resequencer": {"type": "batch", "capacity": 500},
+		"subscription": {"addrs": "unix:///tmp/efr.sock"},
+		"dispatcher": {
+			"delivery": {"U": ["sender"]},
+			"eviction": {"interval": "30s", "orphanTTL": "10m"}
+		},
+		"admin": {"addr": "localhost:9100"}
+	}`))
+
+	if err != nil {
+		t.Fatalf("Cannot read JSON config: %v", err)
+	}
+
+	fromTOML := config.Default()
+
+	err = fromTOML.ReadFile(writeConfigFile(t, "efr.toml", `
+# efr settings
+maxProcs = 2
+admin.addr = 'localhost:9100' # literal string
+
+[listener]
+addrs = [
+    "127.0.0.1:9090",
+    '[::1]:9090', # IPv6
+]
+
+[resequencer]
+type = "batch"
+capacity = 5_00
+
+[subscription]
+addrs = "unix:///tmp/efr.sock"
+
+[dispatcher.delivery]
+U = ["sender"]
+
+[dispatcher.eviction]
+interval = "30s"
+orphanTTL = "10m"
+`))
+
+	if err != nil {
+		t.Fatalf("Cannot read TOML config: %v", err)
+	}
+
+	fromJSON.Path, fromTOML.Path = "", ""
+
+	if !reflect.DeepEqual(fromJSON, fromTOML) {
+		t.Fatalf("TOML config differ from JSON one:\n%+v\n%+v", fromTOML, fromJSON)
+	}
+
+	for _, content := range []string{
+		"maxProcs = ",
+		"maxProcs = 2 3",
+		"maxProcs = 2\nmaxProcs = 3",
+		"[resequencer\ntype = \"batch\"",
+		"[[listener]]",
+		"admin.addr = \"localhost",
+		"admin.addr = \"\"\"localhost\"\"\"",
+		"maxProcs = nan",
+		"resequencer = {kind = \"batch\"}",
+	} {
+		if err := config.Default().ReadFile(writeConfigFile(t, "efr.toml", content)); err == nil {
+			t.Fatalf("Expected error reading %q", content)
+		}
+	}
+}
+
+// TestReloader prove that reloading apply only reloadable settings
+// and report the ones which require a restart
+func TestReloader(t *testing.T) {
+	path := writeConfigFile(t, "efr.json", `{"resequencer": {"capacity": 10}}`)
+	args := []string{"-config", path}
+
+	c, err := config.Load(args, envFromMap(nil))
+
+	if err != nil {
+		t.Fatalf("Cannot load config: %v", err)
+	}
+
+	var applied *config.Config
+
+	r := config.NewReloader(c, args, envFromMap(nil), func(c *config.Config) {
+		applied = c
+	})
+
+	err = ioutil.WriteFile(path, []byte(`{
+		"resequencer": {"capacity": 50},
+		"listener": {"addrs": ":7000"}
+	}`), 0600)
+
+	if err != nil {
+		t.Fatalf("Cannot write config file: %v", err)
+	}
+
+	result, err := r.Reload()
+
+	if err != nil {
+		t.Fatalf("Cannot reload config: %v", err)
+	}
+
+	if len(result.Applied) != 1 || result.Applied[0] != "resequencerCapacity" {
+		t.Fatalf("Expected resequencerCapacity to be applied, got %v", result.Applied)
+	}
+
+	if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "eventSourceAddr" {
+		t.Fatalf("Expected eventSourceAddr to require restart, got %v", result.RestartRequired)
+	}
+
+	if applied == nil || applied.Resequencer.Capacity != 50 {
+		t.Fatalf("Expected new capacity to be applied, got %+v", applied)
+	}
+
+	if current := r.Current(); current.Listener.Addrs.String() != ":9090" {
+		t.Fatalf("Address should not change until restart, got %v", current.Listener.Addrs)
+	}
+
+	// invalid configuration is never applied
+	ioutil.WriteFile(path, []byte(`{"resequencer": {"capacity": 0}}`), 0600)
+
+	if _, err := r.Reload(); err == nil {
+		t.Fatalf("Expected reload of invalid configuration to fail")
+	}
+
+	if current := r.Current(); current.Resequencer.Capacity != 50 {
+		t.Fatalf("Invalid configuration has been applied: %+v", current)
+	}
+
+	// settings without a flag are reported by JSON path
+	ioutil.WriteFile(path, []byte(`{
+		"resequencer": {"capacity": 50},
+		"listener": {"policy": {"action": "reject"}},
+		"dispatcher": {"delivery": {"U": ["sender"]}}
+	}`), 0600)
+
+	result, err = r.Reload()
+
+	if err != nil {
+		t.Fatalf("Cannot reload config: %v", err)
+	}
+
+	expected := []string{"dispatcher.delivery", "listener.policy"}
+
+	if len(result.Applied) != 0 || !reflect.DeepEqual(result.RestartRequired, expected) {
+		t.Fatalf("Expected %v to require restart, got %+v", expected, result)
+	}
+}
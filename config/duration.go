@@ -0,0 +1,45 @@
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration is a time.Duration written in config file and flags
+// as a string, e.g. "90s" or "24h"
+type Duration time.Duration
+
+func (d *Duration) String() string {
+	if d == nil {
+		return ""
+	}
+
+	return time.Duration(*d).String()
+}
+
+// Set implement flag.Value
+func (d *Duration) Set(value string) error {
+	v, err := time.ParseDuration(value)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	return d.Set(value)
+}
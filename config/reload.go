@@ -0,0 +1,202 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// reloadable list settings, by flag name, which can be changed
+// without restarting efr.
+// Resequencer settings are applied to the next EventSource connection.
+var reloadable = map[string]bool{
+	"maxProcs":            true,
+	"resequencerType":     true,
+	"resequencerCapacity": true,
+	"sequenceIndex":       true,
+}
+
+// IsReloadable return whenever the setting named name can be applied
+// to a running efr.
+func IsReloadable(name string) bool {
+	return reloadable[name]
+}
+
+// Changes compare c with next and return the name of the settings
+// which differ, split between the ones which can be applied live
+// and the ones which need a restart.
+// Settings are named after their flag or, when they can only be set
+// in the config file, after their JSON path (e.g. listener.policy.action).
+func (c *Config) Changes(next *Config) (live, restart []string) {
+	values := map[string]string{}
+
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+
+	// c with next flag values, so that it only differ from next
+	// in settings without a flag
+	merged := *c
+	fs := merged.FlagSet()
+
+	next.FlagSet().VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || values[f.Name] == f.Value.String() {
+			return
+		}
+
+		fs.Set(f.Name, f.Value.String())
+
+		if IsReloadable(f.Name) {
+			live = append(live, f.Name)
+		} else {
+			restart = append(restart, f.Name)
+		}
+	})
+
+	restart = append(restart, diffJSON("", jsonValue(&merged), jsonValue(next))...)
+
+	sort.Strings(live)
+	sort.Strings(restart)
+
+	return live, restart
+}
+
+// jsonValue return v decoded from its JSON encoding, so that
+// settings can be compared by JSON path
+func jsonValue(v interface{}) interface{} {
+	var value interface{}
+
+	data, _ := json.Marshal(v)
+	json.Unmarshal(data, &value)
+
+	return value
+}
+
+// diffJSON return the paths, below path, of the values which
+// differ between a and b
+func diffJSON(path string, a, b interface{}) []string {
+	aFields, aIsObject := a.(map[string]interface{})
+	bFields, bIsObject := b.(map[string]interface{})
+
+	if !aIsObject || !bIsObject {
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+
+		return []string{path}
+	}
+
+	keys := map[string]bool{}
+
+	for key := range aFields {
+		keys[key] = true
+	}
+
+	for key := range bFields {
+		keys[key] = true
+	}
+
+	paths := []string{}
+
+	for key := range keys {
+		keyPath := key
+
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		paths = append(paths, diffJSON(keyPath, aFields[key], bFields[key])...)
+	}
+
+	return paths
+}
+
+// ReloadResult report the outcome of a configuration reload
+type ReloadResult struct {
+	// Settings applied to the running process
+	Applied []string `json:"applied"`
+
+	// Settings changed in configuration that will be effective
+	// only after a restart
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reloader load configuration again using the same args and environment
+// used at startup, applying the reloadable subset of settings.
+type Reloader struct {
+	mu sync.Mutex
+
+	args      []string
+	lookupEnv func(string) (string, bool)
+
+	// configuration the process is running with
+	current *Config
+
+	// apply is invoked with the updated configuration after
+	// every successful reload, so that resources read from disk
+	// (e.g. TLS certificates) can be refreshed too.
+	apply func(*Config)
+}
+
+// Current return a copy of the configuration the process is running with
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := *r.current
+
+	return &c
+}
+
+// Reload read configuration again and apply reloadable settings.
+// If the new configuration is not valid nothing is applied.
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := Load(r.args, r.lookupEnv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	live, restart := r.current.Changes(next)
+
+	result := &ReloadResult{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+
+	updated := *r.current
+	fs := updated.FlagSet()
+
+	for _, name := range live {
+		fs.Set(name, next.FlagSet().Lookup(name).Value.String())
+		result.Applied = append(result.Applied, name)
+	}
+
+	result.RestartRequired = append(result.RestartRequired, restart...)
+
+	r.current = &updated
+
+	applied := updated
+	r.apply(&applied)
+
+	return result, nil
+}
+
+func NewReloader(
+	current *Config,
+	args []string,
+	lookupEnv func(string) (string, bool),
+	apply func(*Config),
+) *Reloader {
+	return &Reloader{
+		args:      args,
+		lookupEnv: lookupEnv,
+		current:   current,
+		apply:     apply,
+	}
+}
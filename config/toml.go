@@ -0,0 +1,413 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeTOML read the subset of TOML config files need: tables,
+// inline tables, dotted and quoted keys, strings, integers, floats,
+// booleans and arrays. Multi-line strings, dates and arrays of tables
+// are not supported.
+// The result can be encoded as JSON and decoded into Config.
+func decodeTOML(data []byte) (map[string]interface{}, error) {
+	p := &tomlParser{data: string(data), line: 1}
+	root := map[string]interface{}{}
+	current := root
+
+	for {
+		p.skipBlank(true)
+
+		if p.done() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			table, err := p.table(root)
+
+			if err != nil {
+				return nil, err
+			}
+
+			current = table
+		} else if err := p.keyValue(current); err != nil {
+			return nil, err
+		}
+
+		p.skipBlank(false)
+
+		if !p.done() && p.peek() != '\n' {
+			return nil, p.errorf("expected end of line, got %q", p.peek())
+		}
+	}
+}
+
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %v: %v", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.data[p.pos]
+}
+
+// skipBlank skip spaces and comments and, when newlines is true,
+// line ends
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.done() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.done() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// table read a [table] header, returning the table it name
+func (p *tomlParser) table(root map[string]interface{}) (map[string]interface{}, error) {
+	p.pos++
+
+	if !p.done() && p.peek() == '[' {
+		return nil, p.errorf("arrays of tables are not supported")
+	}
+
+	keys, err := p.keys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.done() || p.peek() != ']' {
+		return nil, p.errorf("expected ] after table name")
+	}
+
+	p.pos++
+
+	table := root
+
+	for _, key := range keys {
+		if table, err = p.subtable(table, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// subtable return the table named key in table, creating it if missing
+func (p *tomlParser) subtable(table map[string]interface{}, key string) (map[string]interface{}, error) {
+	v, exist := table[key]
+
+	if !exist {
+		sub := map[string]interface{}{}
+		table[key] = sub
+
+		return sub, nil
+	}
+
+	sub, ok := v.(map[string]interface{})
+
+	if !ok {
+		return nil, p.errorf("key %q is already defined", key)
+	}
+
+	return sub, nil
+}
+
+// keyValue read a key = value pair into table
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	keys, err := p.keys()
+
+	if err != nil {
+		return err
+	}
+
+	if p.done() || p.peek() != '=' {
+		return p.errorf("expected = after key %q", strings.Join(keys, "."))
+	}
+
+	p.pos++
+	p.skipBlank(false)
+
+	value, err := p.value()
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.subtable(table, key); err != nil {
+			return err
+		}
+	}
+
+	key := keys[len(keys)-1]
+
+	if _, exist := table[key]; exist {
+		return p.errorf("key %q is already defined", key)
+	}
+
+	table[key] = value
+
+	return nil
+}
+
+// keys read a dotted key, whose parts are bare or quoted
+func (p *tomlParser) keys() ([]string, error) {
+	keys := []string{}
+
+	for {
+		p.skipBlank(false)
+
+		if p.done() {
+			return nil, p.errorf("expected key")
+		}
+
+		var key string
+		var err error
+
+		switch c := p.peek(); {
+		case c == '"' || c == '\'':
+			key, err = p.str()
+		default:
+			start := p.pos
+
+			for !p.done() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+
+			if key = p.data[start:p.pos]; key == "" {
+				err = p.errorf("invalid character %q in key", c)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+		p.skipBlank(false)
+
+		if p.done() || p.peek() != '.' {
+			return keys, nil
+		}
+
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	if p.done() {
+		return nil, p.errorf("expected value")
+	}
+
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	}
+
+	start := p.pos
+
+	for !p.done() && !strings.ContainsRune(" \t\r\n#,]}", rune(p.peek())) {
+		p.pos++
+	}
+
+	raw := p.data[start:p.pos]
+
+	switch raw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	number := strings.Replace(raw, "_", "", -1)
+
+	if i, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return i, nil
+	}
+
+	// no inf, nan or hexadecimal floats, which JSON cannot encode
+	if strings.Trim(number, "+-.0123456789eE") == "" {
+		if f, err := strconv.ParseFloat(number, 64); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, p.errorf("invalid value %q", raw)
+}
+
+// str read a basic "string", with escapes, or a literal 'string'
+func (p *tomlParser) str() (string, error) {
+	quote := p.peek()
+	p.pos++
+
+	if strings.HasPrefix(p.data[p.pos:], string([]byte{quote, quote})) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+
+	b := &strings.Builder{}
+
+	for !p.done() {
+		c := p.peek()
+		p.pos++
+
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			if err := p.escape(b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlParser) escape(b *strings.Builder) error {
+	if p.done() {
+		return p.errorf("unterminated string")
+	}
+
+	c := p.peek()
+	p.pos++
+
+	switch c {
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'u', 'U':
+		size := 4
+
+		if c == 'U' {
+			size = 8
+		}
+
+		if p.pos+size > len(p.data) {
+			return p.errorf("invalid unicode escape")
+		}
+
+		code, err := strconv.ParseUint(p.data[p.pos:p.pos+size], 16, 32)
+
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return p.errorf("invalid unicode escape")
+		}
+
+		b.WriteRune(rune(code))
+		p.pos += size
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+
+	return nil
+}
+
+// array read [value, ...], which can span several lines
+func (p *tomlParser) array() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+
+	for {
+		p.skipBlank(true)
+
+		if p.done() {
+			return nil, p.errorf("unterminated array")
+		}
+
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.value()
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+		p.skipBlank(true)
+
+		if !p.done() && p.peek() == ',' {
+			p.pos++
+		} else if p.done() || p.peek() != ']' {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+// inlineTable read {key = value, ...} on a single line
+func (p *tomlParser) inlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := map[string]interface{}{}
+
+	p.skipBlank(false)
+
+	if !p.done() && p.peek() == '}' {
+		p.pos++
+		return table, nil
+	}
+
+	for {
+		if err := p.keyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipBlank(false)
+
+		if p.done() {
+			return nil, p.errorf("unterminated inline table")
+		}
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// deadletter package implement a sink for events rejected by the
+// listener, the resequencer or the dispatcher.
+// Each rejected event is kept, with the reason and where it come from,
+// in an in-memory ring of the last entries, and optionally appended
+// as a JSON line to a file, so it can be inspected, fixed and
+// re-injected instead of being lost.
+package deadletter
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Components rejecting events
+const (
+	LISTENER_STAGE    = "listener"
+	RESEQUENCER_STAGE = "resequencer"
+	DISPATCHER_STAGE  = "dispatcher"
+)
+
+// Number of entries kept in memory by default
+const DefaultSize = 1000
+
+var entriesCount = expvar.NewInt("deadletter.entries")
+
+// Entry is a rejected event
+type Entry struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+
+	// Component which rejected the event
+	Stage string `json:"stage"`
+
+	// Identity of the EventSource which sent the event, if known
+	Source string `json:"source,omitempty"`
+
+	// Raw payload, as received or as the event would be sent
+	Payload string `json:"payload"`
+	Error   string `json:"error"`
+}
+
+// Sink keep the last Size entries in memory, oldest are dropped first.
+// When File is not nil every entry is also written there.
+type Sink struct {
+	Size int
+	File io.Writer
+
+	mu      sync.Mutex
+	entries []*Entry
+	lastID  int
+}
+
+// Add record payload rejected by stage with err
+func (s *Sink) Add(stage, source, payload string, err error) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+
+	entry := &Entry{
+		ID:      s.lastID,
+		Time:    time.Now().UTC(),
+		Stage:   stage,
+		Source:  source,
+		Payload: payload,
+		Error:   err.Error(),
+	}
+
+	s.entries = append(s.entries, entry)
+
+	if len(s.entries) > s.Size {
+		s.entries = s.entries[len(s.entries)-s.Size:]
+	}
+
+	entriesCount.Add(1)
+
+	if s.File != nil {
+		if err := json.NewEncoder(s.File).Encode(entry); err != nil {
+			log.Printf("*** Cannot write dead letter %v: %v", entry.ID, err)
+		}
+	}
+
+	return entry
+}
+
+// Entries return entries kept in memory, oldest first
+func (s *Sink) Entries() []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*Entry, len(s.entries))
+	copy(entries, s.entries)
+
+	return entries
+}
+
+// Get return the entry with id, if still kept in memory
+func (s *Sink) Get(id int) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// Remove drop the entry with id from memory, e.g. once re-injected
+func (s *Sink) Remove(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.ID == id {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// New return a sink keeping size entries in memory and writing
+// them to w, which can be nil
+func New(size int, w io.Writer) *Sink {
+	return &Sink{
+		Size:    size,
+		File:    w,
+		entries: []*Entry{},
+	}
+}
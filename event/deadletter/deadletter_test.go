@@ -0,0 +1,53 @@
+package deadletter_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/andreadipersio/efr/event/deadletter"
+)
+
+// TestSink prove that only the last entries are kept in memory,
+// while every entry is written to file
+func TestSink(t *testing.T) {
+	file := &bytes.Buffer{}
+	sink := deadletter.New(2, file)
+
+	for i := 1; i <= 3; i++ {
+		sink.Add(deadletter.LISTENER_STAGE, "127.0.0.1", fmt.Sprintf("%v|X", i), fmt.Errorf("error %v", i))
+	}
+
+	entries := sink.Entries()
+
+	if len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 3 {
+		t.Fatalf("Expected entries 2 and 3, got %+v", entries)
+	}
+
+	decoder := json.NewDecoder(file)
+
+	for i := 1; i <= 3; i++ {
+		entry := &deadletter.Entry{}
+
+		if err := decoder.Decode(entry); err != nil {
+			t.Fatalf("Cannot decode entry %v: %v", i, err)
+		}
+
+		if entry.ID != i || entry.Payload != fmt.Sprintf("%v|X", i) || entry.Error != fmt.Sprintf("error %v", i) {
+			t.Fatalf("Unexpected entry %+v", entry)
+		}
+	}
+
+	if !sink.Remove(2) || sink.Remove(2) {
+		t.Fatalf("Entry 2 should be removed once")
+	}
+
+	if _, exist := sink.Get(2); exist {
+		t.Fatalf("Removed entry should not be returned")
+	}
+
+	if entry, exist := sink.Get(3); !exist || entry.Stage != deadletter.LISTENER_STAGE {
+		t.Fatalf("Expected entry 3, got %+v", entry)
+	}
+}
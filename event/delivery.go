@@ -0,0 +1,70 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Who can be notified of an event
+const (
+	DELIVER_SENDER    = "sender"
+	DELIVER_RECIPIENT = "recipient"
+	DELIVER_FOLLOWERS = "followers"
+
+	// Followers which the sender follows back
+	DELIVER_MUTUALS = "mutuals"
+
+	// Followers, their followers and so on up to a hop limit
+	DELIVER_NETWORK = "network"
+)
+
+var deliveryTargets = []string{
+	DELIVER_SENDER, DELIVER_RECIPIENT, DELIVER_FOLLOWERS, DELIVER_MUTUALS, DELIVER_NETWORK,
+}
+
+// Delivery list who is notified of an event
+type Delivery []string
+
+// Has report whenever target is notified
+func (d Delivery) Has(target string) bool {
+	for _, t := range d {
+		if t == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeliveryRules map event types to who is notified of them
+type DeliveryRules map[string]Delivery
+
+// Validate return an error if rules contain unknown targets
+func (r DeliveryRules) Validate() error {
+	for eType, delivery := range r {
+		for _, target := range delivery {
+			if !Delivery(deliveryTargets).Has(target) {
+				return fmt.Errorf("unknown delivery target %q for event type %v, should be one of %v",
+					target, eType, strings.Join(deliveryTargets, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Merge return rules from r, replaced by those in overrides
+// for the event types they define
+func (r DeliveryRules) Merge(overrides DeliveryRules) DeliveryRules {
+	merged := DeliveryRules{}
+
+	for eType, delivery := range r {
+		merged[eType] = delivery
+	}
+
+	for eType, delivery := range overrides {
+		merged[eType] = delivery
+	}
+
+	return merged
+}
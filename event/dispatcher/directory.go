@@ -2,18 +2,71 @@ package dispatcher
 
 import (
 	"log"
+	"time"
 
 	"github.com/andreadipersio/efr/event"
 )
 
-// dispatchDirectory provide storing of subscribers by their ids
+// Directory store subscribers by their IDs, with the topics, groups
+// and follow index the dispatcher route events through.
+// Directories are only used from the dispatcher goroutine.
+type Directory interface {
+	GetOrCreate(subscriberID string) event.Subscriber
+	GetByID(subscriberID string) (event.Subscriber, bool)
+	New(subscriberID string)
+	Subscribe(s event.Subscriber)
+	SubscribeWithFilter(s event.Subscriber, filter *event.Filter)
+	UnsubscribeAll()
+
+	Broadcast(e event.Event)
+	Multicast(e event.Event)
+	SenderAndRecipientFromEvent(e event.Event) (event.Subscriber, event.Subscriber)
+
+	// Updated record changes made to s while handling an event
+	Updated(s event.Subscriber)
+
+	SubscribeTopic(pattern, subscriberID string)
+	UnsubscribeTopic(pattern, subscriberID string)
+	TopicMembers(topic string) []string
+	Publish(e event.Event)
+
+	HandleGroupEvent(e event.Event) error
+
+	Followers(subscriberID string) []string
+	Following(subscriberID string) []string
+	Mutuals(subscriberID string) []string
+	FollowCounts(subscriberID string) (int, int)
+	Graph(subscriberID string) *Graph
+
+	SetEvictionPolicy(policy *EvictionPolicy)
+	Evict(now time.Time)
+}
+
+// dispatchDirectory provide storing of subscribers by their ids in memory,
+// saving evicted subscribers to a Store
 type dispatchDirectory struct {
 	storage           map[string]event.Subscriber
 	subscriberFactory event.SubscriberFactoryType
+
+	// subscriber IDs by topic pattern
+	topics map[string]map[string]bool
+
+	// groups by ID, a namespace separated from subscribers
+	groups map[string]*group
+
+	// follow relationships in both directions
+	graph followIndex
+
+	// when set, idle subscribers are evicted, see Evict
+	eviction   *EvictionPolicy
+	lastActive map[string]time.Time
+
+	// evicted subscribers are saved there
+	store Store
 }
 
 // GetOrCreate try to get a subscriber from directory by its ID, if it does not exist,
-// create a disconnected user
+// reload it from eviction store or create a disconnected user
 func (d *dispatchDirectory) GetOrCreate(subscriberID string) event.Subscriber {
 	subscriber, exist := d.storage[subscriberID]
 
@@ -21,7 +74,9 @@ func (d *dispatchDirectory) GetOrCreate(subscriberID string) event.Subscriber {
 		return subscriber
 	}
 
-	d.New(subscriberID)
+	if !d.reload(subscriberID) {
+		d.New(subscriberID)
+	}
 
 	return d.storage[subscriberID]
 }
@@ -38,17 +93,38 @@ func (d *dispatchDirectory) New(subscriberID string) {
 	log.Printf("subscriber %v registered to directory", subscriberID)
 	s := d.subscriberFactory(subscriberID)
 
-	d.storage[subscriberID] = s
+	d.storage[subscriberID] = d.entry(s, nil)
+	d.touch(subscriberID)
 }
 
 // Subscribe register a subscriber value to directory
 func (d *dispatchDirectory) Subscribe(s event.Subscriber) {
+	d.SubscribeWithFilter(s, nil)
+}
+
+// SubscribeWithFilter register a subscriber value to directory,
+// only events allowed by filter will be sent to it.
+// A subscriber already known, in memory or saved, keep its state.
+func (d *dispatchDirectory) SubscribeWithFilter(s event.Subscriber, filter *event.Filter) {
 	log.Printf("subscriber %v subscribed to directory", s)
-	d.storage[s.GetID()] = s
+
+	if state, exist := d.state(s.GetID()); exist && restore(s, state, d.ref) && d.store != nil {
+		if err := d.store.Delete(stateKey(s.GetID())); err != nil {
+			log.Printf("*** Cannot delete saved subscriber %v: %v", s.GetID(), err)
+		}
+	}
+
+	d.storage[s.GetID()] = d.entry(s, filter)
+	d.touch(s.GetID())
 }
 
+// Updated does nothing, subscribers are kept in memory
+// and saved only when evicted
+func (d *dispatchDirectory) Updated(s event.Subscriber) {}
+
 // UnsubscribeAll unsubscribe all subscribers by deleting them from
-// the subscriber directory and, if they are connected, disconnect them
+// the subscriber directory, topics, groups, follow index and eviction
+// store and, if they are connected, disconnect them.
 func (d *dispatchDirectory) UnsubscribeAll() {
 	for subscriberID, s := range d.storage {
 		if s.IsConnected() {
@@ -59,6 +135,17 @@ func (d *dispatchDirectory) UnsubscribeAll() {
 
 		delete(d.storage, subscriberID)
 	}
+
+	d.topics = map[string]map[string]bool{}
+	d.groups = map[string]*group{}
+	d.lastActive = map[string]time.Time{}
+	d.graph = newFollowGraph()
+
+	if d.store != nil {
+		if err := d.store.Clear(); err != nil {
+			log.Printf("*** Cannot clear eviction store: %v", err)
+		}
+	}
 }
 
 // Broadcast send event e to all subscribers in the directory
@@ -69,17 +156,53 @@ func (d *dispatchDirectory) Broadcast(e event.Event) {
 	}
 }
 
+// Multicast send event e once to each subscriber among its recipients.
+// Recipients not in the directory, or blocking the sender, are skipped.
+func (d *dispatchDirectory) Multicast(e event.Event) {
+	sent := map[string]bool{}
+
+	for _, recipientID := range e.RecipientIDs() {
+		if sent[recipientID] {
+			continue
+		}
+
+		sent[recipientID] = true
+
+		if s, exist := d.storage[recipientID]; exist && !s.IsBlocking(e.SenderID()) {
+			s.SendEvent(e)
+		}
+	}
+}
+
 // SenderAndRecipientFromEvent return event Sender and event Receiver.
 // If they are not registered in the directory, create them.
+// Events without recipient get a disconnected subscriber with an
+// empty ID, which is never registered.
 func (d *dispatchDirectory) SenderAndRecipientFromEvent(e event.Event) (event.Subscriber, event.Subscriber) {
+	d.touch(e.SenderID())
+
+	if e.RecipientID() == "" {
+		return d.GetOrCreate(e.SenderID()), d.subscriberFactory("")
+	}
+
+	d.touch(e.RecipientID())
+
 	return d.GetOrCreate(e.SenderID()), d.GetOrCreate(e.RecipientID())
 }
 
 // NewDirectory return an initialized directory which use subscriberFactory to
 // generate new subscriber objects
-func NewDirectory(subscriberFactory event.SubscriberFactoryType) *dispatchDirectory {
+func NewDirectory(subscriberFactory event.SubscriberFactoryType) Directory {
+	return newDirectory(subscriberFactory)
+}
+
+func newDirectory(subscriberFactory event.SubscriberFactoryType) *dispatchDirectory {
 	return &dispatchDirectory{
 		storage:           map[string]event.Subscriber{},
 		subscriberFactory: subscriberFactory,
+		topics:            map[string]map[string]bool{},
+		groups:            map[string]*group{},
+		graph:             newFollowGraph(),
+		lastActive:        map[string]time.Time{},
 	}
 }
@@ -1,13 +1,40 @@
-package dispatcher
+package dispatcher_test
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/andreadipersio/efr/event/dispatcher"
-	"github.com/andreadipersio/efr/event/example"
+	"github.com/andreadipersio/efr/example"
 )
 
-var subscriberFactory = example.NewUser
+// countingStore count subscribers saved to Store
+type countingStore struct {
+	dispatcher.Store
+
+	states int
+}
+
+func (s *countingStore) Save(key string, value []byte) error {
+	if strings.HasPrefix(key, "subscriber/") {
+		s.states++
+	}
+
+	return s.Store.Save(key, value)
+}
+
+// handleAll handle events created from payloads as the dispatcher would
+func handleAll(d dispatcher.Directory, payloads ...string) {
+	for _, payload := range payloads {
+		e, _ := example.NewEvent(payload)
+		sender, recipient := d.SenderAndRecipientFromEvent(e)
+		sender.HandleEvent(e, recipient)
+		d.Updated(sender)
+		d.Updated(recipient)
+	}
+}
 
 func TestGetOrcreate(t *testing.T) {
 	testSubscriberID := "foo"
@@ -46,3 +73,162 @@ func TestNew(t *testing.T) {
 			"to be subscribed. Is not!", testSubscriberID)
 	}
 }
+
+// TestEvict prove that stale saved subscribers are cleared, isolated
+// subscribers are dropped, others are saved and lazily reloaded, and
+// connected subscribers are kept
+func TestEvict(t *testing.T) {
+	store, err := dispatcher.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Cannot create store: %v", err)
+	}
+
+	// saved by a previous run
+	store.Save("subscriber/4", []byte("{}"))
+
+	d := dispatcher.NewDirectory(subscriberFactory)
+	d.SetEvictionPolicy(&dispatcher.EvictionPolicy{
+		OrphanTTL: time.Minute,
+		IdleTTL:   time.Hour,
+		Store:     store,
+	})
+
+	if _, saved, _ := store.Load("subscriber/4"); saved {
+		t.Fatalf("Subscribers saved by a previous run should be cleared")
+	}
+
+	connected := subscriberFactory("3")
+	connected.Connect(&testBuffer{})
+	d.Subscribe(connected)
+
+	// 1 follow 2
+	e, _ := example.NewEvent("1|F|1|2")
+	sender, recipient := d.SenderAndRecipientFromEvent(e)
+	sender.HandleEvent(e, recipient)
+
+	exist := func(subscriberID string) bool {
+		_, ok := d.GetByID(subscriberID)
+		return ok
+	}
+
+	d.Evict(time.Now().Add(2 * time.Minute))
+
+	if exist("1") || !exist("2") || !exist("3") {
+		t.Fatalf("Only isolated subscriber 1 should be evicted")
+	}
+
+	d.Evict(time.Now().Add(2 * time.Hour))
+
+	if exist("2") || !exist("3") {
+		t.Fatalf("Idle subscriber 2 should be evicted, connected 3 kept")
+	}
+
+	if _, saved, _ := store.Load("subscriber/2"); !saved {
+		t.Fatalf("Evicted subscriber 2 should be saved")
+	}
+
+	if !d.GetOrCreate("2").IsFollowedBy("1") {
+		t.Fatalf("Reloaded subscriber 2 should keep its followers")
+	}
+
+	if _, saved, _ := store.Load("subscriber/2"); saved {
+		t.Fatalf("Reloaded subscriber 2 should be deleted from store")
+	}
+}
+
+// TestPersistentDirectory prove that subscribers and follow
+// relationships saved by a persistent directory survive restarts,
+// and that subscribers are saved only when they change
+func TestPersistentDirectory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := dispatcher.NewFileStore(dir)
+
+	if err != nil {
+		t.Fatalf("Cannot create store: %v", err)
+	}
+
+	counter := &countingStore{Store: store}
+	d := dispatcher.NewPersistentDirectory(subscriberFactory, counter, 0)
+
+	// 1 follow 2, 2 follow 1, 3 follow and unfollow 1,
+	// then status updates and messages
+	handleAll(d, "1|F|1|2", "2|F|2|1", "3|F|3|1", "4|U|3|1", "5|S|1", "6|P|1|2")
+
+	if counter.states != 0 {
+		t.Fatalf("Follows, status updates and messages should not save subscribers, saved %v", counter.states)
+	}
+
+	// 1 mute 3
+	handleAll(d, "7|MT|1|3")
+
+	if counter.states != 1 {
+		t.Fatalf("Only subscriber 1 should be saved once muting, saved %v", counter.states)
+	}
+
+	d.UnsubscribeAll()
+
+	// restart
+	if store, err = dispatcher.NewFileStore(dir); err != nil {
+		t.Fatalf("Cannot open store: %v", err)
+	}
+
+	d = dispatcher.NewPersistentDirectory(subscriberFactory, store, 0)
+
+	if _, exist := d.GetByID("1"); exist {
+		t.Fatalf("Subscribers should be loaded lazily")
+	}
+
+	if !d.GetOrCreate("2").IsFollowedBy("1") {
+		t.Fatalf("Reloaded subscriber 2 should keep its followers")
+	}
+
+	if !d.GetOrCreate("1").IsMuting("3") {
+		t.Fatalf("Reloaded subscriber 1 should keep its mutes")
+	}
+
+	g := d.Graph("1")
+
+	if !reflect.DeepEqual(g.Followers, []string{"2"}) ||
+		!reflect.DeepEqual(g.Following, []string{"2"}) ||
+		!reflect.DeepEqual(g.Mutuals, []string{"2"}) {
+		t.Fatalf("Unexpected follow graph of 1: %+v", g)
+	}
+}
+
+// TestPersistentCache prove that a persistent directory keep up to
+// cacheSize disconnected subscribers in memory, besides connected ones,
+// loading the others when needed
+func TestPersistentCache(t *testing.T) {
+	store, err := dispatcher.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Cannot create store: %v", err)
+	}
+
+	d := dispatcher.NewPersistentDirectory(subscriberFactory, store, 2)
+
+	connected := subscriberFactory("1")
+	connected.Connect(&testBuffer{})
+	d.Subscribe(connected)
+
+	// 2 mute 3, then 2, 3 and 4 follow 1
+	handleAll(d, "1|MT|2|3", "2|F|2|1", "3|F|3|1", "4|F|4|1")
+
+	exist := func(subscriberID string) bool {
+		_, ok := d.GetByID(subscriberID)
+		return ok
+	}
+
+	if exist("2") || !exist("1") || !exist("3") || !exist("4") {
+		t.Fatalf("Only the least recently used disconnected subscriber 2 should be dropped")
+	}
+
+	if !reflect.DeepEqual(d.Followers("1"), []string{"2", "3", "4"}) {
+		t.Fatalf("Subscriber 1 should keep its followers, got %v", d.Followers("1"))
+	}
+
+	if !d.GetOrCreate("2").IsMuting("3") {
+		t.Fatalf("Reloaded subscriber 2 should keep its mutes")
+	}
+}
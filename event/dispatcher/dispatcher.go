@@ -1,19 +1,19 @@
 // dispatcher package implement an event dispatcher which
 // dispatch incoming events to subscribers (implementing event.Subscriber interface)
 // registered on a subscribers directory.
+// Besides events handled by subscribers, the dispatcher route broadcast
+// events to everyone, topic events to subscribers of the topic and
+// group events to group members.
 package dispatcher
 
 import (
 	"log"
+	"time"
 
 	"github.com/andreadipersio/efr/event"
 	"github.com/andreadipersio/efr/event/subscription"
 )
 
-const (
-	BROADCAST_ETYPE = "B"
-)
-
 // Dispatcher listen for both new events and new subscription request
 // dispatching events on a subscribers directory
 type Dispatcher struct {
@@ -27,35 +27,66 @@ type Dispatcher struct {
 	// from event listener
 	EventSourceCloseChan chan interface{}
 
+	// queries run by the dispatch loop, which own the directory
+	queryChan chan func()
+
 	// dispatch directory store subscribed users
-	directory *dispatchDirectory
+	directory Directory
 
 	// A function which is used to return the subscriber
 	// concrete value
 	SubscriberFactory event.SubscriberFactoryType
+
+	// DeadLetter, when set, receive invalid events and events
+	// whose handling failed
+	DeadLetter DeadLetterFunc
+
+	// EventTypes, when set, list event types handled by subscribers,
+	// events of other types not routed by the dispatcher itself are
+	// rejected before their sender and recipient are registered
+	EventTypes map[string]bool
+
+	// When set, idle disconnected subscribers are evicted
+	// from the directory
+	Eviction *EvictionPolicy
 }
 
 // Dispatch
 // - receive new subscriptions on subscription channel
 // - receive new events on dispatch channel
+// - run queries on the directory, such as Graph
 // - get notified of event source disconnection on EventSourceCloseChan
+// - evict idle subscribers every Eviction.Interval
 func (dsp *Dispatcher) Dispatch() {
 	log.Print("=== Dispatcher started")
 
+	var evictions <-chan time.Time
+
+	if dsp.Eviction != nil {
+		dsp.directory.SetEvictionPolicy(dsp.Eviction)
+
+		ticker := time.NewTicker(dsp.Eviction.Interval)
+		defer ticker.Stop()
+
+		evictions = ticker.C
+	}
+
 	for {
 		select {
 		case subRequest := <-dsp.SubscriptionChan:
 			s := dsp.SubscriberFactory(subRequest.SubscriberID)
 			s.Connect(subRequest.Conn)
-			dsp.directory.Subscribe(s)
-		case e := <-dsp.DispatchChan:
-			// Broadcast
-			if e.EventType() == BROADCAST_ETYPE {
-				dsp.directory.Broadcast(e)
-			} else {
-				sender, recipient := dsp.directory.SenderAndRecipientFromEvent(e)
-				sender.HandleEvent(e, recipient)
+			dsp.directory.SubscribeWithFilter(s, subRequest.Filter)
+
+			for _, pattern := range subRequest.Topics {
+				dsp.directory.SubscribeTopic(pattern, subRequest.SubscriberID)
 			}
+		case e := <-dsp.DispatchChan:
+			dsp.dispatch(e)
+		case query := <-dsp.queryChan:
+			query()
+		case now := <-evictions:
+			dsp.directory.Evict(now)
 		case <-dsp.EventSourceCloseChan:
 			// EventSource disconnected
 			dsp.directory.UnsubscribeAll()
@@ -63,17 +94,78 @@ func (dsp *Dispatcher) Dispatch() {
 	}
 }
 
+// dispatch deliver e to its recipients, invalid events and
+// handler errors are rejected
+func (dsp *Dispatcher) dispatch(e event.Event) {
+	if err := dsp.validate(e); err != nil {
+		dsp.reject(e, err, invalidEvents)
+		return
+	}
+
+	switch e.EventType() {
+	case event.BROADCAST_ETYPE:
+		dsp.directory.Broadcast(e)
+	case event.MULTICAST_ETYPE:
+		dsp.directory.Multicast(e)
+	case event.TOPIC_ETYPE:
+		dsp.directory.Publish(e)
+	case event.TOPIC_SUBSCRIBE_ETYPE:
+		dsp.directory.SubscribeTopic(e.RecipientID(), e.SenderID())
+	case event.TOPIC_UNSUBSCRIBE_ETYPE:
+		dsp.directory.UnsubscribeTopic(e.RecipientID(), e.SenderID())
+	case event.GROUP_CREATE_ETYPE, event.GROUP_JOIN_ETYPE, event.GROUP_LEAVE_ETYPE, event.GROUP_MESSAGE_ETYPE:
+		if err := dsp.directory.HandleGroupEvent(e); err != nil {
+			dsp.reject(e, err, handlerErrors)
+		}
+	default:
+		sender, recipient := dsp.directory.SenderAndRecipientFromEvent(e)
+
+		if err := sender.HandleEvent(e, recipient); err != nil {
+			dsp.reject(e, err, handlerErrors)
+		}
+
+		// only sender and recipient can change
+		dsp.directory.Updated(sender)
+		dsp.directory.Updated(recipient)
+	}
+}
+
+// Graph return follow relationships of subscriberID.
+// It is safe to call from other goroutines while dispatching.
+func (dsp *Dispatcher) Graph(subscriberID string) *Graph {
+	result := make(chan *Graph, 1)
+
+	dsp.queryChan <- func() {
+		result <- dsp.directory.Graph(subscriberID)
+	}
+
+	return <-result
+}
+
 func New(
 	dspChan chan event.Event,
 	subChan chan *subscription.SubscriptionRequest,
 	ctrlChan chan interface{},
 	subscriberFactory event.SubscriberFactoryType,
+) *Dispatcher {
+	return NewWithDirectory(dspChan, subChan, ctrlChan, subscriberFactory, NewDirectory(subscriberFactory))
+}
+
+// NewWithDirectory return a dispatcher storing subscribers in directory,
+// e.g. a persistent one
+func NewWithDirectory(
+	dspChan chan event.Event,
+	subChan chan *subscription.SubscriptionRequest,
+	ctrlChan chan interface{},
+	subscriberFactory event.SubscriberFactoryType,
+	directory Directory,
 ) *Dispatcher {
 	return &Dispatcher{
 		DispatchChan:         dspChan,
 		SubscriptionChan:     subChan,
 		EventSourceCloseChan: ctrlChan,
 		SubscriberFactory:    subscriberFactory,
-		directory:            NewDirectory(subscriberFactory),
+		directory:            directory,
+		queryChan:            make(chan func()),
 	}
 }
@@ -1,7 +1,9 @@
-package dispatcher
+package dispatcher_test
 
 import (
 	"bytes"
+	"expvar"
+	"strings"
 	"testing"
 	"time"
 
@@ -48,6 +50,69 @@ func createSubscribtionRequest(
 	}
 }
 
+// newDispatcher return a dispatcher creating subscribers with factory
+// and storing them in directory, accepting the example event types.
+// Tests setting more fields start it themselves.
+func newDispatcher(factory event.SubscriberFactoryType, directory dispatcher.Directory) *dispatcher.Dispatcher {
+	dsp := dispatcher.NewWithDirectory(
+		make(chan event.Event),
+		make(chan *subscription.SubscriptionRequest),
+		make(chan interface{}),
+		factory,
+		directory,
+	)
+
+	dsp.EventTypes = example.EventTypes
+
+	return dsp
+}
+
+// startDispatcher return a running dispatcher creating subscribers
+// with factory, kept in memory
+func startDispatcher(factory event.SubscriberFactoryType) *dispatcher.Dispatcher {
+	dsp := newDispatcher(factory, dispatcher.NewDirectory(factory))
+
+	go dsp.Dispatch()
+
+	return dsp
+}
+
+// subscribeAll subscribe a request for each of subscriberIDs
+func subscribeAll(dsp *dispatcher.Dispatcher, subscriberIDs ...string) []*subscription.SubscriptionRequest {
+	srs := []*subscription.SubscriptionRequest{}
+
+	for _, subscriberID := range subscriberIDs {
+		sr := createSubscribtionRequest(subscriberID)
+		dsp.SubscriptionChan <- sr
+		srs = append(srs, sr)
+	}
+
+	return srs
+}
+
+// waitDispatched return once everything sent to the dispatcher so far
+// has been handled: queries run in the dispatch loop, after anything
+// received before them, and leave the directory unchanged
+func waitDispatched(dsp *dispatcher.Dispatcher) {
+	dsp.Graph("")
+}
+
+// dispatchAll send events created from payloads, returning once
+// every one has been handled
+func dispatchAll(dsp *dispatcher.Dispatcher, payloads ...string) {
+	for _, payload := range payloads {
+		e, _ := eventFactory(payload)
+		dsp.DispatchChan <- e
+	}
+
+	waitDispatched(dsp)
+}
+
+// content return the last event written to the subscriber of sr
+func content(sr *subscription.SubscriptionRequest) string {
+	return sr.Conn.(*testBuffer).Content
+}
+
 // TestDispatcher prove that dispatcher is able to dispatch
 // broadcast events to two subscribers
 func TestDispatcher(t *testing.T) {
@@ -103,3 +168,340 @@ func TestDispatcher(t *testing.T) {
 	check(sr1.Conn.(*testBuffer))
 	check(sr2.Conn.(*testBuffer))
 }
+
+// TestTopics prove that topic events reach subscribers of matching
+// patterns, subscribed at handshake or through topic events
+func TestTopics(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	sports := createSubscribtionRequest("1")
+	sports.Topics = []string{"sports.*"}
+
+	dsp.SubscriptionChan <- sports
+	srs := subscribeAll(dsp, "2", "3")
+
+	dispatchAll(dsp,
+		"1|TS|2|sports.football",
+		"2|TS|3|news",
+		"3|T|9|sports.football",
+		"4|TU|2|sports.football",
+		"5|T|9|sports.tennis",
+	)
+
+	for sr, expected := range map[*subscription.SubscriptionRequest]string{
+		sports: "5|T|9|sports.tennis\n",
+		srs[0]: "3|T|9|sports.football\n",
+		srs[1]: "",
+	} {
+		if c := content(sr); c != expected {
+			t.Fatalf("Subscriber %v: expected '%v', got '%v'", sr.SubscriberID, expected, c)
+		}
+	}
+}
+
+// TestFilter prove that subscribers only receive events allowed by
+// their filter, including status updates sent to followers and
+// unfollow confirmations sent to themselves, once enabled
+func TestFilter(t *testing.T) {
+	dsp := startDispatcher(example.NewUserFactory(event.DeliveryRules{
+		example.UNFOLLOW_ETYPE: {event.DELIVER_SENDER},
+	}, 0))
+
+	messagesOnly := createSubscribtionRequest("1")
+	messagesOnly.Filter = &event.Filter{Types: []string{"P"}}
+
+	noStatus := createSubscribtionRequest("2")
+	noStatus.Filter = &event.Filter{ExcludeTypes: []string{"S"}}
+
+	dsp.SubscriptionChan <- messagesOnly
+	dsp.SubscriptionChan <- noStatus
+
+	dispatchAll(dsp, "1|F|1|3", "2|F|2|3", "3|P|3|1", "4|S|3", "5|B", "6|U|1|3", "7|U|2|3")
+
+	for sr, expected := range map[*subscription.SubscriptionRequest]string{
+		messagesOnly: "3|P|3|1\n",
+		noStatus:     "7|U|2|3\n",
+	} {
+		if c := content(sr); c != expected {
+			t.Fatalf("Subscriber %v: expected '%v', got '%v'", sr.SubscriberID, expected, c)
+		}
+	}
+}
+
+// TestMulticast prove that multicast events reach exactly the listed
+// subscribers which are in the directory
+func TestMulticast(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	srs := subscribeAll(dsp, "1", "2", "3")
+
+	dispatchAll(dsp, "1|M|9|1,3,1,42")
+
+	for i, expected := range []string{"1|M|9|1,3,1,42\n", "", "1|M|9|1,3,1,42\n"} {
+		if c := content(srs[i]); c != expected {
+			t.Fatalf("Subscriber %v: expected '%v', got '%v'", srs[i].SubscriberID, expected, c)
+		}
+	}
+}
+
+// TestGroups prove that group messages reach members only, and that
+// members are notified of joins and leaves
+func TestGroups(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	srs := subscribeAll(dsp, "1", "2", "3", "4")
+
+	dispatchAll(dsp, "1|GC|1|friends", "2|GJ|2|friends")
+
+	if content(srs[0]) != "2|GJ|2|friends\n" || content(srs[1]) != "" {
+		t.Fatalf("Only existing members should be notified of join, got '%v' '%v'", content(srs[0]), content(srs[1]))
+	}
+
+	dispatchAll(dsp, "3|GJ|3|friends", "4|GM|2|friends", "5|GM|4|friends")
+
+	if content(srs[0]) != "4|GM|2|friends\n" || content(srs[2]) != "4|GM|2|friends\n" || content(srs[3]) != "" {
+		t.Fatalf("Group message should reach members only, got '%v' '%v' '%v'", content(srs[0]), content(srs[2]), content(srs[3]))
+	}
+
+	if content(srs[1]) != "3|GJ|3|friends\n" {
+		t.Fatalf("Sender should not receive its own message, got '%v'", content(srs[1]))
+	}
+
+	dispatchAll(dsp, "6|GL|3|friends")
+
+	if content(srs[0]) != "6|GL|3|friends\n" || content(srs[1]) != "6|GL|3|friends\n" || content(srs[2]) != "4|GM|2|friends\n" {
+		t.Fatalf("Remaining members should be notified of leave, got '%v' '%v' '%v'", content(srs[0]), content(srs[1]), content(srs[2]))
+	}
+}
+
+// TestBlockAndMute prove that blocked users cannot reach the blocker
+// and lose their follow, while muted users keep it but their status
+// updates are hidden
+func TestBlockAndMute(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	srs := subscribeAll(dsp, "1", "2", "3")
+
+	// 2 and 3 follow 1, 1 block 2, 3 mute 1
+	dispatchAll(dsp, "1|F|2|1", "2|F|3|1", "3|BK|1|2", "4|MT|3|1", "5|S|1")
+
+	if content(srs[1]) != "" || content(srs[2]) != "" {
+		t.Fatalf("Status update should not reach blocked or muting followers, got '%v' '%v'", content(srs[1]), content(srs[2]))
+	}
+
+	dispatchAll(dsp, "6|P|2|1", "7|F|2|1")
+
+	if content(srs[0]) != "2|F|3|1\n" {
+		t.Fatalf("Blocked user should not reach blocker, got '%v'", content(srs[0]))
+	}
+
+	dispatchAll(dsp, "8|UM|3|1", "9|S|1")
+
+	if content(srs[2]) != "9|S|1\n" {
+		t.Fatalf("Follow should be kept while muted, got '%v'", content(srs[2]))
+	}
+
+	if content(srs[1]) != "" {
+		t.Fatalf("Follow should be removed by block, got '%v'", content(srs[1]))
+	}
+
+	// 2 multicast, publish to a topic and join a group of 1
+	dispatchAll(dsp, "10|M|2|1,3", "11|TS|1|news", "12|T|2|news",
+		"13|GC|1|friends", "14|GJ|2|friends", "15|GM|2|friends")
+
+	if content(srs[0]) != "2|F|3|1\n" {
+		t.Fatalf("Multicast, topic and group events of blocked user should not reach blocker, got '%v'", content(srs[0]))
+	}
+
+	if content(srs[2]) != "10|M|2|1,3\n" {
+		t.Fatalf("Multicast should reach other recipients, got '%v'", content(srs[2]))
+	}
+}
+
+// TestFollowRequests prove that follows to private users are pending
+// until accepted, and that only accepted followers get status updates
+func TestFollowRequests(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	srs := subscribeAll(dsp, "1", "2", "3")
+
+	// 1 is private, 2 and 3 ask to follow
+	dispatchAll(dsp, "1|PV|1", "2|F|2|1", "3|F|3|1")
+
+	if content(srs[0]) != "3|F|3|1\n" {
+		t.Fatalf("Follow request should be delivered, got '%v'", content(srs[0]))
+	}
+
+	dispatchAll(dsp, "4|S|1")
+
+	if content(srs[1]) != "" || content(srs[2]) != "" {
+		t.Fatalf("Pending followers should not get status updates, got '%v' '%v'", content(srs[1]), content(srs[2]))
+	}
+
+	dispatchAll(dsp, "5|FA|1|2", "6|FD|1|3", "7|S|1")
+
+	if content(srs[1]) != "7|S|1\n" {
+		t.Fatalf("Accepted follower should get status updates, got '%v'", content(srs[1]))
+	}
+
+	if content(srs[2]) != "" {
+		t.Fatalf("Declined follower should not be notified, got '%v'", content(srs[2]))
+	}
+}
+
+// TestDeliveryRules prove that who is notified of an event follow
+// the rules users are created with
+func TestDeliveryRules(t *testing.T) {
+	dsp := startDispatcher(example.NewUserFactory(event.DeliveryRules{
+		"U": {event.DELIVER_SENDER, event.DELIVER_RECIPIENT},
+	}, 0))
+
+	srs := subscribeAll(dsp, "1", "2")
+
+	dispatchAll(dsp, "1|F|1|2", "2|U|1|2")
+
+	for _, sr := range srs {
+		if c := content(sr); c != "2|U|1|2\n" {
+			t.Fatalf("Subscriber %v should be notified of unfollow, got '%v'", sr.SubscriberID, c)
+		}
+	}
+}
+
+// TestGraph prove that follow relationships are indexed in both
+// directions, including follows changed by blocks, accepted requests,
+// going public and unfollows
+func TestGraph(t *testing.T) {
+	dsp := startDispatcher(subscriberFactory)
+
+	dispatchAll(dsp,
+		"1|F|1|2", "2|F|2|1", "3|F|1|3", "4|F|3|2",
+		"5|PV|4", "6|F|1|4", "7|F|2|4", "8|FA|4|1",
+		"9|BK|2|3", "10|PB|4", "11|F|3|1", "12|U|3|1",
+	)
+
+	testData := []struct {
+		id                   string
+		followers, following string
+		mutuals              string
+	}{
+		{"1", "2", "2,3,4", "2"},
+		{"2", "1", "1,4", "1"},
+		{"3", "1", "", ""},
+		{"4", "1,2", "", ""},
+	}
+
+	for _, test := range testData {
+		g := dsp.Graph(test.id)
+
+		for _, check := range []struct {
+			name     string
+			ids      []string
+			count    int
+			expected string
+		}{
+			{"followers", g.Followers, g.FollowersCount, test.followers},
+			{"following", g.Following, g.FollowingCount, test.following},
+			{"mutuals", g.Mutuals, len(g.Mutuals), test.mutuals},
+		} {
+			if ids := strings.Join(check.ids, ","); ids != check.expected || check.count != len(check.ids) {
+				t.Fatalf("Expected %v %v of %v, got '%v' (%v)",
+					check.name, check.expected, test.id, ids, check.count)
+			}
+		}
+	}
+}
+
+// TestPersistentDelivery prove that followers kept by a persistent
+// directory are notified as in memory, and that connected subscribers
+// stay in memory beyond its cache size
+func TestPersistentDelivery(t *testing.T) {
+	store, err := dispatcher.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Cannot create store: %v", err)
+	}
+
+	dsp := newDispatcher(subscriberFactory, dispatcher.NewPersistentDirectory(subscriberFactory, store, 1))
+
+	go dsp.Dispatch()
+
+	srs := subscribeAll(dsp, "1", "2", "3")
+
+	// 2 and 3 follow 1, 1 follow 2 back
+	dispatchAll(dsp, "1|F|2|1", "2|F|3|1", "3|F|1|2", "4|S|1", "5|SC|1")
+
+	for i, expected := range []string{"2|F|3|1\n", "5|SC|1\n", "4|S|1\n"} {
+		if c := content(srs[i]); c != expected {
+			t.Fatalf("Subscriber %v should receive '%v', got '%v'", srs[i].SubscriberID, expected, c)
+		}
+	}
+}
+
+// TestNetworkDelivery prove that close friends status updates reach
+// mutual followers only, and network delivery stop at the hop limit
+func TestNetworkDelivery(t *testing.T) {
+	dsp := startDispatcher(example.NewUserFactory(event.DeliveryRules{
+		"S": {event.DELIVER_NETWORK},
+	}, 2))
+
+	srs := subscribeAll(dsp, "1", "2", "3", "4", "5")
+
+	// 2 follow 1, 3 follow 2, 4 follow 3, 1 and 5 follow each other
+	dispatchAll(dsp, "1|F|2|1", "2|F|3|2", "3|F|4|3", "4|F|5|1", "5|F|1|5")
+
+	dispatchAll(dsp, "6|SC|1")
+
+	for i, expected := range []string{"4|F|5|1\n", "2|F|3|2\n", "3|F|4|3\n", "", "6|SC|1\n"} {
+		if c := content(srs[i]); c != expected {
+			t.Fatalf("Expected %v to receive '%v', got '%v'", srs[i].SubscriberID, expected, c)
+		}
+	}
+
+	dispatchAll(dsp, "7|S|1")
+
+	for i, expected := range []string{"4|F|5|1\n", "7|S|1\n", "7|S|1\n", "", "7|S|1\n"} {
+		if c := content(srs[i]); c != expected {
+			t.Fatalf("Expected %v to receive '%v', got '%v'", srs[i].SubscriberID, expected, c)
+		}
+	}
+}
+
+// TestRejectedEvents prove that invalid events and handler errors
+// are handed to DeadLetter and counted, without stopping dispatch
+func TestRejectedEvents(t *testing.T) {
+	dsp := newDispatcher(subscriberFactory, dispatcher.NewDirectory(subscriberFactory))
+
+	rejected := []string{}
+
+	dsp.DeadLetter = func(e event.Event, err error) {
+		rejected = append(rejected, e.String())
+	}
+
+	go dsp.Dispatch()
+
+	follower := subscribeAll(dsp, "2")[0]
+
+	counter := func(name string) int64 {
+		return expvar.Get(name).(*expvar.Int).Value()
+	}
+
+	invalid, failed := counter("dispatcher.invalidEvents"), counter("dispatcher.handlerErrors")
+
+	dispatchAll(dsp, "1|F|2|1", "2|S", "3|M|1", "4|GJ|1", "5|F|1", "6|XX|1|2", "7|FA|1|3", "8|P|1|2,3", "9|S|1")
+
+	if r := strings.Join(rejected, ","); r != "2|S,3|M|1,4|GJ|1,5|F|1,6|XX|1|2,7|FA|1|3,8|P|1|2,3" {
+		t.Fatalf("Unexpected rejected events '%v'", r)
+	}
+
+	if n := counter("dispatcher.invalidEvents") - invalid; n != 5 {
+		t.Fatalf("Expected 5 invalid events, got %v", n)
+	}
+
+	if n := counter("dispatcher.handlerErrors") - failed; n != 2 {
+		t.Fatalf("Expected 2 handler errors, got %v", n)
+	}
+
+	if c := content(follower); c != "9|S|1\n" {
+		t.Fatalf("Status update after rejected events should be delivered, got '%v'", c)
+	}
+}
@@ -0,0 +1,209 @@
+package dispatcher
+
+import (
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+var (
+	evictedSubscribers  = expvar.NewInt("dispatcher.evictedSubscribers")
+	reloadedSubscribers = expvar.NewInt("dispatcher.reloadedSubscribers")
+)
+
+// EvictionPolicy define when disconnected subscribers are removed
+// from the directory. A subscriber is idle since the last event it
+// sent or received, or since it subscribed.
+type EvictionPolicy struct {
+	// How often the directory is checked
+	Interval time.Duration
+
+	// Isolated subscribers (see event.Subscriber IsIsolated) idle
+	// for longer are evicted, 0 to keep them
+	OrphanTTL time.Duration
+
+	// Other subscribers idle for longer are saved to Store and
+	// evicted, 0 to keep them.
+	// Only subscribers implementing event.PersistentSubscriber
+	// can be saved.
+	IdleTTL time.Duration
+
+	// Evicted subscribers state is saved there, and lazily reloaded
+	// when they are needed again.
+	// It is cleared when the policy is set and when the event source
+	// disconnect, since the subscribers it refer to are only in memory.
+	// Persistent directories use their own store instead.
+	Store Store
+}
+
+// ref return a reference to subscriberID, see subscriberRef
+func (d *dispatchDirectory) ref(subscriberID string) event.Subscriber {
+	return newRef(d, subscriberID)
+}
+
+// touch mark subscriberID as active now
+func (d *dispatchDirectory) touch(subscriberID string) {
+	if d.eviction != nil {
+		d.lastActive[subscriberID] = time.Now()
+	}
+}
+
+// state return the saved state of subscriberID, from memory
+// or from store
+func (d *dispatchDirectory) state(subscriberID string) ([]byte, bool) {
+	if s, exist := d.storage[subscriberID]; exist {
+		return snapshot(s.(*filteredSubscriber).Subscriber)
+	}
+
+	if d.store == nil {
+		return nil, false
+	}
+
+	state, exist, err := d.store.Load(stateKey(subscriberID))
+
+	if err != nil {
+		log.Printf("*** Cannot reload subscriber %v: %v", subscriberID, err)
+		return nil, false
+	}
+
+	return state, exist
+}
+
+// snapshot return the state of s, false when s cannot be saved
+func snapshot(s event.Subscriber) ([]byte, bool) {
+	persistent, ok := s.(event.PersistentSubscriber)
+
+	if !ok {
+		return nil, false
+	}
+
+	state, err := persistent.Snapshot()
+
+	if err != nil {
+		log.Printf("*** Cannot save subscriber %v: %v", s.GetID(), err)
+		return nil, false
+	}
+
+	return state, true
+}
+
+// restore replace s state with state, followers are resolved with
+// resolve, returning false when s cannot be restored
+func restore(s event.Subscriber, state []byte, resolve func(string) event.Subscriber) bool {
+	persistent, ok := s.(event.PersistentSubscriber)
+
+	if !ok {
+		return false
+	}
+
+	if err := persistent.Restore(state, resolve); err != nil {
+		log.Printf("*** %v", err)
+		return false
+	}
+
+	return true
+}
+
+// reload restore subscriberID from store, returning false
+// when it has not been saved there.
+// Evicted subscribers are deleted from eviction store once reloaded.
+func (d *dispatchDirectory) reload(subscriberID string) bool {
+	if d.store == nil {
+		return false
+	}
+
+	state, exist := d.state(subscriberID)
+
+	if !exist {
+		return false
+	}
+
+	s := d.subscriberFactory(subscriberID)
+
+	if !restore(s, state, d.ref) {
+		return false
+	}
+
+	if err := d.store.Delete(stateKey(subscriberID)); err != nil {
+		log.Printf("*** Cannot delete saved subscriber %v: %v", subscriberID, err)
+	}
+
+	d.storage[subscriberID] = d.entry(s, nil)
+	d.touch(subscriberID)
+	reloadedSubscribers.Add(1)
+
+	log.Printf("subscriber %v reloaded to directory", subscriberID)
+
+	return true
+}
+
+// save write state of subscriberID, which is in memory, to store
+func (d *dispatchDirectory) save(subscriberID string) bool {
+	if d.store == nil {
+		return false
+	}
+
+	state, exist := d.state(subscriberID)
+
+	if !exist {
+		return false
+	}
+
+	if err := d.store.Save(stateKey(subscriberID), state); err != nil {
+		log.Printf("*** Cannot save subscriber %v: %v", subscriberID, err)
+		return false
+	}
+
+	return true
+}
+
+// Evict remove from the directory disconnected subscribers idle
+// for longer than eviction policy allow, as of now
+func (d *dispatchDirectory) Evict(now time.Time) {
+	if d.eviction == nil {
+		return
+	}
+
+	for subscriberID, s := range d.storage {
+		if s.IsConnected() {
+			continue
+		}
+
+		idle := now.Sub(d.lastActive[subscriberID])
+
+		switch {
+		case d.eviction.OrphanTTL > 0 && idle >= d.eviction.OrphanTTL && s.IsIsolated():
+		case d.eviction.IdleTTL > 0 && idle >= d.eviction.IdleTTL && d.save(subscriberID):
+		default:
+			continue
+		}
+
+		delete(d.storage, subscriberID)
+		delete(d.lastActive, subscriberID)
+		evictedSubscribers.Add(1)
+
+		log.Printf("subscriber %v evicted from directory", subscriberID)
+	}
+}
+
+// SetEvictionPolicy start tracking subscribers activity, so that
+// they can be evicted according to policy.
+// Subscribers saved to policy store by a previous run are deleted.
+func (d *dispatchDirectory) SetEvictionPolicy(policy *EvictionPolicy) {
+	d.eviction = policy
+	d.store = policy.Store
+
+	if d.store != nil {
+		if err := d.store.Clear(); err != nil {
+			log.Printf("*** Cannot clear eviction store: %v", err)
+		}
+	}
+
+	now := time.Now()
+
+	for subscriberID := range d.storage {
+		d.lastActive[subscriberID] = now
+	}
+}
@@ -0,0 +1,61 @@
+package dispatcher
+
+import (
+	"github.com/andreadipersio/efr/event"
+)
+
+// filteredSubscriber wrap every subscriber stored in the directory,
+// dropping events not allowed by its filter before they are sent
+type filteredSubscriber struct {
+	event.Subscriber
+
+	filter    *event.Filter
+	directory subscribers
+}
+
+// SendEvent send e only if the subscriber filter allow it
+func (s *filteredSubscriber) SendEvent(e event.Event) {
+	if !s.filter.Allow(e) {
+		return
+	}
+
+	s.Subscriber.SendEvent(e)
+}
+
+// NewFollower store a reference to the directory entry of follower,
+// since subscribers handling events pass themselves unwrapped,
+// bypassing their filter, and entries can be evicted or replaced.
+// The follow is indexed, see Followers.
+func (s *filteredSubscriber) NewFollower(follower event.Subscriber) {
+	s.Subscriber.NewFollower(s.directory.ref(follower.GetID()))
+	s.directory.index().follow(follower.GetID(), s.GetID())
+}
+
+// RemoveFollower remove followerID from followers and from the index
+func (s *filteredSubscriber) RemoveFollower(followerID string) {
+	s.Subscriber.RemoveFollower(followerID)
+	s.directory.index().unfollow(followerID, s.GetID())
+}
+
+// NewFollowRequest store a reference to follower, see NewFollower
+func (s *filteredSubscriber) NewFollowRequest(follower event.Subscriber) {
+	s.Subscriber.NewFollowRequest(s.directory.ref(follower.GetID()))
+}
+
+// entry return the directory entry of s, sending events through filter
+func (d *dispatchDirectory) entry(s event.Subscriber, filter *event.Filter) event.Subscriber {
+	return setEntry(s, &filteredSubscriber{Subscriber: s, filter: filter, directory: d})
+}
+
+// setEntry make entry the entry of s, see event.EntrySubscriber
+func setEntry(s event.Subscriber, entry event.Subscriber) event.Subscriber {
+	if s, ok := s.(event.EntrySubscriber); ok {
+		s.SetEntry(entry)
+	}
+
+	return entry
+}
+
+func (s *filteredSubscriber) String() string {
+	return s.GetID()
+}
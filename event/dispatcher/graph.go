@@ -0,0 +1,147 @@
+package dispatcher
+
+import (
+	"sort"
+)
+
+// followIndex index follow relationships in both directions,
+// so that who a subscriber follows is known without scanning
+// every subscriber followers
+type followIndex interface {
+	follow(followerID, followedID string)
+	unfollow(followerID, followedID string)
+
+	// whenever followerID follows followedID
+	follows(followerID, followedID string) bool
+
+	// IDs of subscribers following, and followed by, subscriberID
+	followers(subscriberID string) map[string]bool
+	following(subscriberID string) map[string]bool
+}
+
+// followGraph is a followIndex kept in memory
+type followGraph struct {
+	// followers by followed ID, and followed IDs by follower
+	byFollowed map[string]map[string]bool
+	byFollower map[string]map[string]bool
+}
+
+func newFollowGraph() *followGraph {
+	return &followGraph{
+		byFollowed: map[string]map[string]bool{},
+		byFollower: map[string]map[string]bool{},
+	}
+}
+
+func link(index map[string]map[string]bool, from, to string) {
+	set, exist := index[from]
+
+	if !exist {
+		set = map[string]bool{}
+		index[from] = set
+	}
+
+	set[to] = true
+}
+
+func unlink(index map[string]map[string]bool, from, to string) {
+	delete(index[from], to)
+
+	if len(index[from]) == 0 {
+		delete(index, from)
+	}
+}
+
+func (g *followGraph) follow(followerID, followedID string) {
+	link(g.byFollowed, followedID, followerID)
+	link(g.byFollower, followerID, followedID)
+}
+
+func (g *followGraph) unfollow(followerID, followedID string) {
+	unlink(g.byFollowed, followedID, followerID)
+	unlink(g.byFollower, followerID, followedID)
+}
+
+func (g *followGraph) follows(followerID, followedID string) bool {
+	return g.byFollower[followerID][followedID]
+}
+
+func (g *followGraph) followers(subscriberID string) map[string]bool {
+	return g.byFollowed[subscriberID]
+}
+
+func (g *followGraph) following(subscriberID string) map[string]bool {
+	return g.byFollower[subscriberID]
+}
+
+// sorted return IDs in set in a stable order
+func sorted(set map[string]bool) []string {
+	ids := make([]string, 0, len(set))
+
+	for id := range set {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Graph describe follow relationships of a subscriber
+type Graph struct {
+	SubscriberID   string   `json:"subscriberID"`
+	Followers      []string `json:"followers"`
+	Following      []string `json:"following"`
+	Mutuals        []string `json:"mutuals"`
+	FollowersCount int      `json:"followersCount"`
+	FollowingCount int      `json:"followingCount"`
+}
+
+func (d *dispatchDirectory) index() followIndex {
+	return d.graph
+}
+
+// Followers return IDs of subscribers following subscriberID
+func (d *dispatchDirectory) Followers(subscriberID string) []string {
+	return sorted(d.graph.followers(subscriberID))
+}
+
+// Following return IDs of subscribers followed by subscriberID
+func (d *dispatchDirectory) Following(subscriberID string) []string {
+	return sorted(d.graph.following(subscriberID))
+}
+
+// Mutuals return IDs of subscribers both following and followed
+// by subscriberID
+func (d *dispatchDirectory) Mutuals(subscriberID string) []string {
+	mutuals := map[string]bool{}
+	following := d.graph.following(subscriberID)
+
+	for followerID := range d.graph.followers(subscriberID) {
+		if following[followerID] {
+			mutuals[followerID] = true
+		}
+	}
+
+	return sorted(mutuals)
+}
+
+// FollowCounts return how many subscribers follow, and are followed
+// by, subscriberID
+func (d *dispatchDirectory) FollowCounts(subscriberID string) (int, int) {
+	return len(d.graph.followers(subscriberID)), len(d.graph.following(subscriberID))
+}
+
+// Graph return follow relationships of subscriberID
+func (d *dispatchDirectory) Graph(subscriberID string) *Graph {
+	followers, following := d.FollowCounts(subscriberID)
+
+	return &Graph{
+		SubscriberID:   subscriberID,
+		Followers:      d.Followers(subscriberID),
+		Following:      d.Following(subscriberID),
+		Mutuals:        d.Mutuals(subscriberID),
+		FollowersCount: followers,
+		FollowingCount: following,
+	}
+}
@@ -0,0 +1,101 @@
+package dispatcher
+
+import (
+	"fmt"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// group is a chat room: messages sent to it are delivered to its members.
+// Like followers, members are notified when someone join or leave;
+// they are stored by ID and looked up in the directory when sending,
+// so members keep receiving messages after reconnecting.
+// Groups are kept in memory only, also by persistent directories:
+// they are lost when the event source disconnect or on restart.
+type group struct {
+	id      string
+	members map[string]bool
+}
+
+func (g *group) IsMember(subscriberID string) bool {
+	return g.members[subscriberID]
+}
+
+func (g *group) NewMember(subscriberID string) {
+	g.members[subscriberID] = true
+}
+
+func (g *group) RemoveMember(subscriberID string) {
+	delete(g.members, subscriberID)
+}
+
+// membersBroadcast send e to members, except the one which sent it
+// and those blocking it
+func (g *group) membersBroadcast(d *dispatchDirectory, e event.Event) {
+	for memberID := range g.members {
+		if memberID == e.SenderID() {
+			continue
+		}
+
+		if s, exist := d.storage[memberID]; exist && !s.IsBlocking(e.SenderID()) {
+			s.SendEvent(e)
+		}
+	}
+}
+
+// HandleGroupEvent apply a group event, whose sender is the subscriber
+// acting and recipient the group:
+//      'GC' Group Create: create the group, sender become its first member
+//      'GJ' Group Join: notify members, then add sender to them
+//      'GL' Group Leave: remove sender from members, then notify them
+//      'GM' Group Message: send to all members, sender must be one of them
+func (d *dispatchDirectory) HandleGroupEvent(e event.Event) error {
+	groupID, senderID := e.RecipientID(), e.SenderID()
+
+	if groupID == "" || senderID == "" {
+		return fmt.Errorf("Group event %v require both sender and group", e)
+	}
+
+	g, exist := d.groups[groupID]
+
+	if e.EventType() == event.GROUP_CREATE_ETYPE {
+		if exist {
+			return fmt.Errorf("Group %v already exists", groupID)
+		}
+
+		d.groups[groupID] = &group{id: groupID, members: map[string]bool{senderID: true}}
+
+		return nil
+	}
+
+	if !exist {
+		return fmt.Errorf("Group %v does not exist", groupID)
+	}
+
+	switch e.EventType() {
+	case event.GROUP_JOIN_ETYPE:
+		if g.IsMember(senderID) {
+			return fmt.Errorf("%v is already a member of group %v", senderID, groupID)
+		}
+
+		g.membersBroadcast(d, e)
+		g.NewMember(senderID)
+	case event.GROUP_LEAVE_ETYPE:
+		if !g.IsMember(senderID) {
+			return fmt.Errorf("%v is not a member of group %v", senderID, groupID)
+		}
+
+		g.RemoveMember(senderID)
+		g.membersBroadcast(d, e)
+	case event.GROUP_MESSAGE_ETYPE:
+		if !g.IsMember(senderID) {
+			return fmt.Errorf("%v is not a member of group %v", senderID, groupID)
+		}
+
+		g.membersBroadcast(d, e)
+	default:
+		return fmt.Errorf("Unsupported group event %v", e)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package dispatcher
+
+import (
+	"container/list"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// Disconnected subscribers a persistent directory keep in memory
+const DefaultCacheSize = 10000
+
+// persistentDirectory is a Directory saving subscribers, and each
+// follow relationship on its own, to a Store as they change.
+// Up to cacheSize disconnected subscribers are kept in memory, the
+// least recently used are dropped and loaded again when needed.
+// Topics, groups and delivery work as in memory, so topics and groups
+// do not survive restarts nor event source disconnections.
+type persistentDirectory struct {
+	*dispatchDirectory
+
+	store     Store
+	cacheSize int
+
+	// IDs of subscribers in memory, least recently used last
+	recent   *list.List
+	elements map[string]*list.Element
+
+	// hash of subscribers state when they were last saved or loaded,
+	// so that they are saved again only when it changes
+	saved map[string]uint64
+}
+
+// storedFollowers is the entry of persistent directory subscribers,
+// keeping their followers in the follow index instead of in memory
+type storedFollowers struct {
+	*filteredSubscriber
+}
+
+func (s *storedFollowers) NewFollower(follower event.Subscriber) {
+	s.directory.index().follow(follower.GetID(), s.GetID())
+}
+
+func (s *storedFollowers) GetFollowers() []event.Subscriber {
+	followers := []event.Subscriber{}
+
+	for _, id := range sorted(s.directory.index().followers(s.GetID())) {
+		followers = append(followers, s.directory.ref(id))
+	}
+
+	return followers
+}
+
+func (s *storedFollowers) IsFollowedBy(subscriberID string) bool {
+	return s.directory.index().follows(subscriberID, s.GetID())
+}
+
+func (s *storedFollowers) IsIsolated() bool {
+	return s.Subscriber.IsIsolated() && len(s.directory.index().followers(s.GetID())) == 0
+}
+
+func (p *persistentDirectory) ref(subscriberID string) event.Subscriber {
+	return newRef(p, subscriberID)
+}
+
+// entry return the directory entry of s, see storedFollowers
+func (p *persistentDirectory) entry(s event.Subscriber, filter *event.Filter) event.Subscriber {
+	return setEntry(s, &storedFollowers{&filteredSubscriber{Subscriber: s, filter: filter, directory: p}})
+}
+
+// GetOrCreate get a subscriber from memory, from store or create it
+func (p *persistentDirectory) GetOrCreate(subscriberID string) event.Subscriber {
+	if s, exist := p.storage[subscriberID]; exist {
+		p.use(subscriberID)
+		return s
+	}
+
+	s := p.subscriberFactory(subscriberID)
+
+	if state, exist := p.state(subscriberID); exist && restore(s, state, p.ref) {
+		reloadedSubscribers.Add(1)
+		log.Printf("subscriber %v reloaded to directory", subscriberID)
+	} else {
+		log.Printf("subscriber %v registered to directory", subscriberID)
+	}
+
+	p.add(s, nil)
+
+	return p.storage[subscriberID]
+}
+
+// New create a new disconnected subscriber, replacing its saved state
+func (p *persistentDirectory) New(subscriberID string) {
+	log.Printf("subscriber %v registered to directory", subscriberID)
+
+	if err := p.store.Delete(stateKey(subscriberID)); err != nil {
+		log.Printf("*** Cannot delete saved subscriber %v: %v", subscriberID, err)
+	}
+
+	p.add(p.subscriberFactory(subscriberID), nil)
+}
+
+func (p *persistentDirectory) Subscribe(s event.Subscriber) {
+	p.SubscribeWithFilter(s, nil)
+}
+
+// SubscribeWithFilter register a subscriber, which keep its saved state,
+// only events allowed by filter will be sent to it
+func (p *persistentDirectory) SubscribeWithFilter(s event.Subscriber, filter *event.Filter) {
+	log.Printf("subscriber %v subscribed to directory", s)
+
+	p.trim()
+
+	if state, exist := p.state(s.GetID()); exist {
+		restore(s, state, p.ref)
+	}
+
+	p.add(s, filter)
+}
+
+// SenderAndRecipientFromEvent return event sender and recipient, loading
+// or creating them, see dispatchDirectory SenderAndRecipientFromEvent.
+// Subscribers exceeding cacheSize are dropped first, so that those
+// used while handling the event stay in memory.
+func (p *persistentDirectory) SenderAndRecipientFromEvent(e event.Event) (event.Subscriber, event.Subscriber) {
+	p.trim()
+
+	if e.RecipientID() == "" {
+		return p.GetOrCreate(e.SenderID()), p.subscriberFactory("")
+	}
+
+	return p.GetOrCreate(e.SenderID()), p.GetOrCreate(e.RecipientID())
+}
+
+// Updated save s if its state changed since it was last saved or loaded
+func (p *persistentDirectory) Updated(s event.Subscriber) {
+	entry, ok := s.(*storedFollowers)
+
+	// missing recipient
+	if !ok || s.GetID() == "" {
+		return
+	}
+
+	state, ok := snapshot(entry.Subscriber)
+
+	if !ok || p.saved[s.GetID()] == hash(state) {
+		return
+	}
+
+	if err := p.store.Save(stateKey(s.GetID()), state); err != nil {
+		log.Printf("*** Cannot save subscriber %v: %v", s.GetID(), err)
+		return
+	}
+
+	p.saved[s.GetID()] = hash(state)
+}
+
+// UnsubscribeAll disconnect subscribers and forget topics and groups,
+// subscribers and follow index stay saved
+func (p *persistentDirectory) UnsubscribeAll() {
+	for subscriberID, s := range p.storage {
+		if s.IsConnected() {
+			s.Disconnect()
+		}
+
+		log.Printf("subscriber %v unsubscribed from directory", subscriberID)
+	}
+
+	p.storage = map[string]event.Subscriber{}
+	p.topics = map[string]map[string]bool{}
+	p.groups = map[string]*group{}
+	p.lastActive = map[string]time.Time{}
+	p.recent = list.New()
+	p.elements = map[string]*list.Element{}
+	p.saved = map[string]uint64{}
+}
+
+// SetEvictionPolicy start tracking subscribers activity, the store of
+// policy is not used since subscribers are already saved
+func (p *persistentDirectory) SetEvictionPolicy(policy *EvictionPolicy) {
+	p.eviction = policy
+
+	now := time.Now()
+
+	for subscriberID := range p.storage {
+		p.lastActive[subscriberID] = now
+	}
+}
+
+// Evict drop from memory disconnected subscribers idle for longer
+// than eviction policy allow, as of now
+func (p *persistentDirectory) Evict(now time.Time) {
+	if p.eviction == nil {
+		return
+	}
+
+	for subscriberID, s := range p.storage {
+		if s.IsConnected() {
+			continue
+		}
+
+		idle := now.Sub(p.lastActive[subscriberID])
+
+		switch {
+		case p.eviction.OrphanTTL > 0 && idle >= p.eviction.OrphanTTL && s.IsIsolated():
+		case p.eviction.IdleTTL > 0 && idle >= p.eviction.IdleTTL:
+		default:
+			continue
+		}
+
+		p.unload(subscriberID)
+	}
+}
+
+// add store the entry of s in memory, s is the most recently used
+func (p *persistentDirectory) add(s event.Subscriber, filter *event.Filter) {
+	p.storage[s.GetID()] = p.entry(s, filter)
+	p.use(s.GetID())
+
+	if state, ok := snapshot(s); ok {
+		p.saved[s.GetID()] = hash(state)
+	}
+}
+
+// use mark subscriberID as the most recently used, and active now
+func (p *persistentDirectory) use(subscriberID string) {
+	p.touch(subscriberID)
+
+	if e, exist := p.elements[subscriberID]; exist {
+		p.recent.MoveToFront(e)
+		return
+	}
+
+	p.elements[subscriberID] = p.recent.PushFront(subscriberID)
+}
+
+// trim drop least recently used disconnected subscribers
+// exceeding cacheSize
+func (p *persistentDirectory) trim() {
+	for e := p.recent.Back(); e != nil && len(p.storage) > p.cacheSize; {
+		subscriberID := e.Value.(string)
+		e = e.Prev()
+
+		if !p.storage[subscriberID].IsConnected() {
+			p.unload(subscriberID)
+		}
+	}
+}
+
+// unload drop subscriberID, which is saved, from memory
+func (p *persistentDirectory) unload(subscriberID string) {
+	delete(p.storage, subscriberID)
+	delete(p.lastActive, subscriberID)
+	delete(p.saved, subscriberID)
+
+	p.recent.Remove(p.elements[subscriberID])
+	delete(p.elements, subscriberID)
+
+	evictedSubscribers.Add(1)
+
+	log.Printf("subscriber %v evicted from directory", subscriberID)
+}
+
+// state return the state of subscriberID, from memory or from store
+func (p *persistentDirectory) state(subscriberID string) ([]byte, bool) {
+	if s, exist := p.storage[subscriberID]; exist {
+		return snapshot(s.(*storedFollowers).Subscriber)
+	}
+
+	state, exist, err := p.store.Load(stateKey(subscriberID))
+
+	if err != nil {
+		log.Printf("*** Cannot reload subscriber %v: %v", subscriberID, err)
+		return nil, false
+	}
+
+	return state, exist
+}
+
+func hash(state []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(state)
+
+	return h.Sum64()
+}
+
+// NewPersistentDirectory return a directory saving subscribers, which
+// have to implement event.PersistentSubscriber, and follow relationships
+// to store as they change, so that they survive restarts and are not
+// bound by memory.
+// Up to cacheSize disconnected subscribers (DefaultCacheSize when 0)
+// are kept in memory, others are loaded when needed.
+func NewPersistentDirectory(subscriberFactory event.SubscriberFactoryType, store Store, cacheSize int) Directory {
+	d := newDirectory(subscriberFactory)
+	d.graph = &storeGraph{store: store}
+
+	if cacheSize == 0 {
+		cacheSize = DefaultCacheSize
+	}
+
+	return &persistentDirectory{
+		dispatchDirectory: d,
+		store:             store,
+		cacheSize:         cacheSize,
+		recent:            list.New(),
+		elements:          map[string]*list.Element{},
+		saved:             map[string]uint64{},
+	}
+}
@@ -0,0 +1,77 @@
+package dispatcher
+
+import (
+	"io"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// subscriberRef refer to a directory subscriber by ID, resolving it
+// on every call. Followers and follow requests are stored as references,
+// so they keep working once the subscriber they refer to has been
+// evicted and reloaded, or replaced by a new subscription.
+type subscriberRef struct {
+	id        string
+	directory subscribers
+}
+
+// subscribers is implemented by directories, whose entries and
+// references find subscribers and the follow index through them
+type subscribers interface {
+	GetOrCreate(subscriberID string) event.Subscriber
+	GetByID(subscriberID string) (event.Subscriber, bool)
+	ref(subscriberID string) event.Subscriber
+	index() followIndex
+}
+
+func newRef(directory subscribers, subscriberID string) *subscriberRef {
+	return &subscriberRef{id: subscriberID, directory: directory}
+}
+
+func (r *subscriberRef) resolve() event.Subscriber {
+	return r.directory.GetOrCreate(r.id)
+}
+
+// loaded return the subscriber if it is in memory
+func (r *subscriberRef) loaded() (event.Subscriber, bool) {
+	return r.directory.GetByID(r.id)
+}
+
+func (r *subscriberRef) Connect(c io.WriteCloser) { r.resolve().Connect(c) }
+func (r *subscriberRef) Disconnect()              { r.resolve().Disconnect() }
+
+// IsConnected does not reload evicted subscribers, which are disconnected
+func (r *subscriberRef) IsConnected() bool {
+	s, exist := r.loaded()
+
+	return exist && s.IsConnected()
+}
+
+func (r *subscriberRef) GetID() string    { return r.id }
+func (r *subscriberRef) SetID(id string)  { r.resolve().SetID(id) }
+func (r *subscriberRef) String() string   { return r.id }
+func (r *subscriberRef) Init()            { r.resolve().Init() }
+func (r *subscriberRef) IsPrivate() bool  { return r.resolve().IsPrivate() }
+func (r *subscriberRef) IsIsolated() bool { return r.resolve().IsIsolated() }
+
+func (r *subscriberRef) HandleEvent(e event.Event, recipient event.Subscriber) error {
+	return r.resolve().HandleEvent(e, recipient)
+}
+
+// SendEvent does not reload evicted subscribers, which are disconnected
+func (r *subscriberRef) SendEvent(e event.Event) {
+	if s, exist := r.loaded(); exist {
+		s.SendEvent(e)
+	}
+}
+
+func (r *subscriberRef) GetFollowers() []event.Subscriber { return r.resolve().GetFollowers() }
+func (r *subscriberRef) NewFollower(s event.Subscriber)   { r.resolve().NewFollower(s) }
+func (r *subscriberRef) RemoveFollower(id string)         { r.resolve().RemoveFollower(id) }
+func (r *subscriberRef) IsFollowedBy(id string) bool      { return r.resolve().IsFollowedBy(id) }
+func (r *subscriberRef) GetFollowRequests() []event.Subscriber {
+	return r.resolve().GetFollowRequests()
+}
+func (r *subscriberRef) NewFollowRequest(s event.Subscriber) { r.resolve().NewFollowRequest(s) }
+func (r *subscriberRef) IsBlocking(id string) bool           { return r.resolve().IsBlocking(id) }
+func (r *subscriberRef) IsMuting(id string) bool             { return r.resolve().IsMuting(id) }
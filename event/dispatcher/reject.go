@@ -0,0 +1,69 @@
+package dispatcher
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+var (
+	invalidEvents = expvar.NewInt("dispatcher.invalidEvents")
+	handlerErrors = expvar.NewInt("dispatcher.handlerErrors")
+)
+
+// DeadLetterFunc receive events which the dispatcher could not route,
+// or whose handling failed, with the reason
+type DeadLetterFunc func(e event.Event, err error)
+
+// validate return an error if e lack the fields needed to route it,
+// list several recipients without being a multicast or, when
+// EventTypes is set, is of an unknown type
+func (dsp *Dispatcher) validate(e event.Event) error {
+	if e.EventType() != event.MULTICAST_ETYPE &&
+		strings.Contains(e.RecipientID(), event.RECIPIENT_DELIMITER) {
+		return fmt.Errorf("Event %v has an invalid recipient", e)
+	}
+
+	switch e.EventType() {
+	case event.BROADCAST_ETYPE:
+		return nil
+	case event.MULTICAST_ETYPE:
+		if len(e.RecipientIDs()) == 0 {
+			return fmt.Errorf("Event %v has no recipients", e)
+		}
+
+		return nil
+	case event.TOPIC_ETYPE, event.TOPIC_SUBSCRIBE_ETYPE, event.TOPIC_UNSUBSCRIBE_ETYPE,
+		event.GROUP_CREATE_ETYPE, event.GROUP_JOIN_ETYPE, event.GROUP_LEAVE_ETYPE, event.GROUP_MESSAGE_ETYPE:
+		if e.SenderID() == "" || e.RecipientID() == "" {
+			return fmt.Errorf("Event %v require both sender and recipient", e)
+		}
+
+		return nil
+	}
+
+	if dsp.EventTypes != nil && !dsp.EventTypes[e.EventType()] {
+		return fmt.Errorf("Event %v has an unsupported type", e)
+	}
+
+	// recipient is optional, e.g. for status updates
+	if e.SenderID() == "" {
+		return fmt.Errorf("Event %v has no sender", e)
+	}
+
+	return nil
+}
+
+// reject log e and hand it to DeadLetter, counter is incremented
+func (dsp *Dispatcher) reject(e event.Event, err error, counter *expvar.Int) {
+	counter.Add(1)
+
+	log.Printf("*** Event %v rejected: %v", e, err)
+
+	if dsp.DeadLetter != nil {
+		dsp.DeadLetter(e, err)
+	}
+}
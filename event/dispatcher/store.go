@@ -0,0 +1,297 @@
+package dispatcher
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Store is a key/value store on local disk, keeping the state of
+// evicted subscribers until they are reloaded or, for a persistent
+// directory, every subscriber and the follow index.
+// Keys are made of parts separated by '|', keys sharing all parts
+// but the last are in the same bucket, which can be listed.
+type Store interface {
+	Save(key string, value []byte) error
+
+	// Load return the value saved for key, and false when there is none
+	Load(key string) ([]byte, bool, error)
+
+	Delete(key string) error
+
+	// List return, sorted, the last part of keys in bucket,
+	// the parts they share followed by '|'
+	List(bucket string) ([]string, error)
+
+	// Clear delete every saved value
+	Clear() error
+}
+
+// Keys of values saved in a Store, prefixed by their kind.
+// Each follow is saved once per direction, in the bucket of the
+// subscriber it belongs to: IDs never contain '|', which separate
+// event fields.
+func stateKey(subscriberID string) string        { return "subscriber/" + subscriberID }
+func followersBucket(subscriberID string) string { return "followers|" + subscriberID + "|" }
+func followingBucket(subscriberID string) string { return "following|" + subscriberID + "|" }
+
+// storeGraph is a followIndex kept in a Store, so that it survive
+// restarts and is not bound by memory
+type storeGraph struct {
+	store Store
+}
+
+func (g *storeGraph) set(bucket string) map[string]bool {
+	set := map[string]bool{}
+	ids, err := g.store.List(bucket)
+
+	if err != nil {
+		log.Printf("*** Cannot read %v: %v", bucket, err)
+	}
+
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+func (g *storeGraph) write(key string, add bool) {
+	var err error
+
+	if add {
+		err = g.store.Save(key, nil)
+	} else {
+		err = g.store.Delete(key)
+	}
+
+	if err != nil {
+		log.Printf("*** Cannot write %v: %v", key, err)
+	}
+}
+
+func (g *storeGraph) follow(followerID, followedID string) {
+	g.write(followersBucket(followedID)+followerID, true)
+	g.write(followingBucket(followerID)+followedID, true)
+}
+
+func (g *storeGraph) unfollow(followerID, followedID string) {
+	g.write(followersBucket(followedID)+followerID, false)
+	g.write(followingBucket(followerID)+followedID, false)
+}
+
+func (g *storeGraph) follows(followerID, followedID string) bool {
+	_, exist, err := g.store.Load(followingBucket(followerID) + followedID)
+
+	if err != nil {
+		log.Printf("*** Cannot read %v: %v", followingBucket(followerID), err)
+	}
+
+	return exist
+}
+
+func (g *storeGraph) followers(subscriberID string) map[string]bool {
+	return g.set(followersBucket(subscriberID))
+}
+
+func (g *storeGraph) following(subscriberID string) map[string]bool {
+	return g.set(followingBucket(subscriberID))
+}
+
+const (
+	stateFileExt = ".state"
+
+	// name of empty key parts, hex encoding never produce it
+	emptyPart = "_"
+)
+
+// FileStore save each value to a file in Dir, synced to disk before
+// Save and Delete return. Key parts are hex encoded into a path,
+// so that buckets are directories.
+type FileStore struct {
+	Dir string
+}
+
+func encodePart(part string) string {
+	if part == "" {
+		return emptyPart
+	}
+
+	return hex.EncodeToString([]byte(part))
+}
+
+// dir return the directory of bucket
+func (s *FileStore) dir(bucket string) string {
+	path := []string{s.Dir}
+
+	if bucket != "" {
+		for _, part := range strings.Split(strings.TrimSuffix(bucket, "|"), "|") {
+			path = append(path, encodePart(part))
+		}
+	}
+
+	return filepath.Join(path...)
+}
+
+func (s *FileStore) path(key string) string {
+	i := strings.LastIndex(key, "|")
+
+	return filepath.Join(s.dir(key[:i+1]), encodePart(key[i+1:])+stateFileExt)
+}
+
+// syncDir make changes to the entries of dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Save atomically replace the value of key
+func (s *FileStore) Save(key string, value []byte) error {
+	dir := filepath.Dir(s.path(key))
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(key)); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func (s *FileStore) Load(key string) ([]byte, bool, error) {
+	value, err := ioutil.ReadFile(s.path(key))
+
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(s.path(key)))
+}
+
+func (s *FileStore) List(bucket string) ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir(bucket))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []string{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if entry.IsDir() || !strings.HasSuffix(name, stateFileExt) {
+			continue
+		}
+
+		name = strings.TrimSuffix(name, stateFileExt)
+
+		if name == emptyPart {
+			parts = append(parts, "")
+			continue
+		}
+
+		part, err := hex.DecodeString(name)
+
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, string(part))
+	}
+
+	sort.Strings(parts)
+
+	return parts, nil
+}
+
+// Clear delete saved values and buckets, leaving other files in Dir
+func (s *FileStore) Clear() error {
+	entries, err := ioutil.ReadDir(s.Dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), stateFileExt)
+
+		if _, err := hex.DecodeString(name); err != nil && name != emptyPart {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(s.Dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(s.Dir)
+}
+
+// NewFileStore return a FileStore saving values in dir,
+// which is created if missing
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Cannot create store directory: %v", err)
+	}
+
+	return &FileStore{Dir: dir}, nil
+}
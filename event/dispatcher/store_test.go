@@ -0,0 +1,84 @@
+package dispatcher_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/andreadipersio/efr/event/dispatcher"
+)
+
+// TestFileStore prove that values are found once saved, also after
+// reopening the store, that deleted values are not, that buckets list
+// only their own keys and that Clear leave other files in place
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := dispatcher.NewFileStore(dir)
+
+	if err != nil {
+		t.Fatalf("Cannot create store: %v", err)
+	}
+
+	for _, key := range []string{"subscriber/1", "a|1|x", "a|1|y", "a|1|", "a|1|z", "a|2|x", "a|1|sub|x"} {
+		if err := store.Save(key, []byte(key)); err != nil {
+			t.Fatalf("Cannot save %v: %v", key, err)
+		}
+	}
+
+	store.Delete("a|1|z")
+	store.Delete("missing")
+	store.Save("subscriber/1", []byte("one"))
+
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("keep"), 0600)
+
+	// restart
+	if store, err = dispatcher.NewFileStore(dir); err != nil {
+		t.Fatalf("Cannot open store: %v", err)
+	}
+
+	for _, test := range []struct {
+		key   string
+		value string
+		exist bool
+	}{
+		{"subscriber/1", "one", true},
+		{"a|1|x", "a|1|x", true},
+		{"a|1|", "a|1|", true},
+		{"a|1|z", "", false},
+		{"a|3|x", "", false},
+	} {
+		value, exist, err := store.Load(test.key)
+
+		if err != nil || exist != test.exist || string(value) != test.value {
+			t.Fatalf("Expected %v to exist %v with '%v', got %v '%v' (%v)",
+				test.key, test.exist, test.value, exist, string(value), err)
+		}
+	}
+
+	for bucket, expected := range map[string][]string{
+		"a|1|": {"", "x", "y"},
+		"a|2|": {"x"},
+		"a|3|": nil,
+	} {
+		if keys, err := store.List(bucket); err != nil || !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("Expected bucket %v to list %v, got %v (%v)", bucket, expected, keys, err)
+		}
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Cannot clear store: %v", err)
+	}
+
+	if _, exist, _ := store.Load("subscriber/1"); exist {
+		t.Fatalf("Cleared store should not keep subscriber/1")
+	}
+
+	if keys, _ := store.List("a|1|"); len(keys) != 0 {
+		t.Fatalf("Cleared store should not keep bucket a|1|, got %v", keys)
+	}
+
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "README")); err != nil {
+		t.Fatalf("Clear should leave other files: %v", err)
+	}
+}
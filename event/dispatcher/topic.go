@@ -0,0 +1,76 @@
+package dispatcher
+
+import (
+	"log"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// SubscribeTopic add subscriberID to topics matching pattern.
+// Membership is kept by ID, so it survive reconnections.
+func (d *dispatchDirectory) SubscribeTopic(pattern, subscriberID string) {
+	if err := event.ValidateTopicPattern(pattern); err != nil {
+		log.Printf("*** Subscriber %v cannot subscribe: %v", subscriberID, err)
+		return
+	}
+
+	members, exist := d.topics[pattern]
+
+	if !exist {
+		members = map[string]bool{}
+		d.topics[pattern] = members
+	}
+
+	members[subscriberID] = true
+}
+
+// UnsubscribeTopic remove subscriberID from topics matching pattern,
+// pattern should be the same used to subscribe
+func (d *dispatchDirectory) UnsubscribeTopic(pattern, subscriberID string) {
+	members := d.topics[pattern]
+
+	delete(members, subscriberID)
+
+	if len(members) == 0 {
+		delete(d.topics, pattern)
+	}
+}
+
+// TopicMembers return IDs of subscribers to topic, each reported once
+// even if subscribed through several patterns
+func (d *dispatchDirectory) TopicMembers(topic string) []string {
+	seen := map[string]bool{}
+	members := []string{}
+
+	for pattern, subscriberIDs := range d.topics {
+		if !event.MatchTopic(pattern, topic) {
+			continue
+		}
+
+		for subscriberID := range subscriberIDs {
+			if !seen[subscriberID] {
+				seen[subscriberID] = true
+				members = append(members, subscriberID)
+			}
+		}
+	}
+
+	return members
+}
+
+// Publish send e to every subscriber of the topic in e recipient field,
+// except those blocking its sender
+func (d *dispatchDirectory) Publish(e event.Event) {
+	topic := e.RecipientID()
+
+	if err := event.ValidateTopic(topic); err != nil {
+		log.Printf("*** Cannot publish event %v: %v", e, err)
+		return
+	}
+
+	for _, subscriberID := range d.TopicMembers(topic) {
+		if s, exist := d.storage[subscriberID]; exist && !s.IsBlocking(e.SenderID()) {
+			s.SendEvent(e)
+		}
+	}
+}
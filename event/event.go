@@ -3,10 +3,41 @@ package event
 
 import "fmt"
 
+// Event types routed by the dispatcher itself, other types are
+// handled by subscribers
+const (
+	BROADCAST_ETYPE = "B"
+
+	// Sent to each subscriber listed in recipient field, separated
+	// by RECIPIENT_DELIMITER, which no other recipient can contain
+	MULTICAST_ETYPE     = "M"
+	RECIPIENT_DELIMITER = ","
+
+	// Publish to the topic in recipient field
+	TOPIC_ETYPE = "T"
+
+	// Subscribe and unsubscribe sender to the topic pattern
+	// in recipient field
+	TOPIC_SUBSCRIBE_ETYPE   = "TS"
+	TOPIC_UNSUBSCRIBE_ETYPE = "TU"
+
+	// Create, join, leave and send a message to the group
+	// in recipient field
+	GROUP_CREATE_ETYPE  = "GC"
+	GROUP_JOIN_ETYPE    = "GJ"
+	GROUP_LEAVE_ETYPE   = "GL"
+	GROUP_MESSAGE_ETYPE = "GM"
+)
+
 type Event interface {
 	SequenceNum() int
+	// Used to number events which are not created by a producer
+	SetSequenceNum(int)
 	SenderID() string
 	RecipientID() string
+	// Recipients of multicast events, a single element
+	// for other events
+	RecipientIDs() []string
 	EventType() string
 
 	Parse(string) error
@@ -23,3 +54,9 @@ func (e BySequence) Less(i, j int) bool { return e[i].SequenceNum() < e[j].Seque
 // EventFactory represent a function that taken a string
 // return an event concrete value or an error
 type EventFactoryType func(string) (Event, error)
+
+// CommandFactoryType represent a function that given the ID of a
+// subscriber and a command it sent, e.g. "FOLLOW 56", return an event
+// sent by that subscriber, or an error if the command is not supported.
+// The event sequence number is assigned later by the server.
+type CommandFactoryType func(subscriberID, command string) (Event, error)
@@ -0,0 +1,48 @@
+package event
+
+// Filter restrict events sent to a subscriber by type and sender.
+// Include lists, when not empty, are the only values allowed;
+// exclude lists are applied afterwards. A nil Filter allow everything.
+type Filter struct {
+	Types        []string
+	ExcludeTypes []string
+
+	// Events without a sender (e.g. broadcast) are dropped
+	// when Senders is not empty
+	Senders        []string
+	ExcludeSenders []string
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowed apply an include and an exclude list to value
+func allowed(include, exclude []string, value string) bool {
+	if len(include) > 0 && !contains(include, value) {
+		return false
+	}
+
+	return !contains(exclude, value)
+}
+
+// Allow report whenever e can be sent to a subscriber using f
+func (f *Filter) Allow(e Event) bool {
+	if f == nil {
+		return true
+	}
+
+	return allowed(f.Types, f.ExcludeTypes, e.EventType()) &&
+		allowed(f.Senders, f.ExcludeSenders, e.SenderID())
+}
+
+// IsEmpty report whenever f allow everything
+func (f *Filter) IsEmpty() bool {
+	return f == nil || len(f.Types)+len(f.ExcludeTypes)+len(f.Senders)+len(f.ExcludeSenders) == 0
+}
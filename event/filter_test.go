@@ -0,0 +1,36 @@
+package event_test
+
+import (
+	"testing"
+
+	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/example"
+)
+
+func TestFilter(t *testing.T) {
+	type testDataType struct {
+		filter  *event.Filter
+		payload string
+		allow   bool
+	}
+
+	testData := []testDataType{
+		testDataType{nil, "1|S|12", true},
+		testDataType{&event.Filter{Types: []string{"P", "F"}}, "1|P|12|13", true},
+		testDataType{&event.Filter{Types: []string{"P", "F"}}, "1|S|12", false},
+		testDataType{&event.Filter{ExcludeTypes: []string{"S"}}, "1|S|12", false},
+		testDataType{&event.Filter{ExcludeTypes: []string{"S"}}, "1|B", true},
+		testDataType{&event.Filter{Senders: []string{"12"}}, "1|S|12", true},
+		testDataType{&event.Filter{Senders: []string{"12"}}, "1|B", false},
+		testDataType{&event.Filter{ExcludeSenders: []string{"12"}}, "1|S|12", false},
+		testDataType{&event.Filter{Types: []string{"S"}, ExcludeSenders: []string{"12"}}, "1|S|13", true},
+	}
+
+	for _, td := range testData {
+		e, _ := example.NewEvent(td.payload)
+
+		if allow := td.filter.Allow(e); allow != td.allow {
+			t.Fatalf("Filter %+v on %v: expected %v, got %v", td.filter, td.payload, td.allow, allow)
+		}
+	}
+}
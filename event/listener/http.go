@@ -0,0 +1,158 @@
+package listener
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andreadipersio/efr/netutil"
+)
+
+// Largest request body accepted by the ingestion endpoint
+const maxIngestionBody = 10 << 20
+
+// IngestionResult report whenever a single event has been accepted
+type IngestionResult struct {
+	Payload  string `json:"payload"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// decodePayloads read event payloads from request body, either
+//     text/plain: one payload per line
+//     application/json: a payload string or an array of payload strings
+func decodePayloads(r *http.Request) ([]string, int, error) {
+	contentType := r.Header.Get("Content-Type")
+
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, err
+	}
+
+	body := http.MaxBytesReader(nil, r.Body, maxIngestionBody)
+	payloads := []string{}
+
+	switch mediaType {
+	case "text/plain":
+		scanner := bufio.NewScanner(body)
+
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				payloads = append(payloads, line)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	case "application/json":
+		raw := json.RawMessage{}
+
+		if err := json.NewDecoder(body).Decode(&raw); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		var single string
+
+		if err := json.Unmarshal(raw, &single); err == nil {
+			payloads = append(payloads, single)
+		} else if err := json.Unmarshal(raw, &payloads); err != nil {
+			return nil, http.StatusBadRequest,
+				fmt.Errorf("body should be a payload string or an array of payload strings")
+		}
+	default:
+		return nil, http.StatusUnsupportedMediaType,
+			fmt.Errorf("unsupported content type %v", mediaType)
+	}
+
+	return payloads, http.StatusOK, nil
+}
+
+// ErrHTTPRequireSequencer is returned when posting events to a listener
+// without a Sequencer
+var ErrHTTPRequireSequencer = errors.New("HTTP ingestion requires server sequencing")
+
+// ServeHTTP accept events on POST /events, sending them through
+// the same parsing and policy path used for EventSource connections.
+// Since producers posting events are not connected, they cannot be
+// resequenced, so events are numbered on arrival by Sequencer, which
+// is required.
+// Response body list, in the same order, the outcome for each payload:
+// accepted events have been sent to the dispatcher.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/events" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payloads, code, err := decodePayloads(r)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot read events: %v", err), code)
+		return
+	}
+
+	if l.Sequencer == nil {
+		http.Error(w, ErrHTTPRequireSequencer.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	source := HTTPSourceIdentity(r)
+	results := []*IngestionResult{}
+
+	for _, payload := range payloads {
+		result := &IngestionResult{Payload: payload, Accepted: true}
+
+		if err := l.ingest(source, payload, nil); err != nil {
+			result.Accepted = false
+			result.Error = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"results": results}); err != nil {
+		log.Printf("Cannot encode ingestion response: %v", err)
+	}
+}
+
+// ListenHTTP accept events from producers on HTTPAddr
+func (l *Listener) ListenHTTP() {
+	if l.Sequencer == nil {
+		log.Fatalf("Cannot start Event Listener HTTP server: %v", ErrHTTPRequireSequencer)
+	}
+
+	ln, err := netutil.Listen(l.HTTPAddr, l.Socket)
+
+	if err != nil {
+		log.Fatalf("Cannot start Event Listener HTTP server: %v", err)
+	}
+
+	if l.TLSConfig != nil {
+		ln = tls.NewListener(ln, l.TLSConfig)
+	}
+
+	log.Printf("=== Event Listener accepting events on http://%v/events (TLS %v)", l.HTTPAddr, l.TLSConfig != nil)
+
+	if err := http.Serve(ln, l); err != nil {
+		log.Fatalf("Event Listener HTTP server stopped: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package listener_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/event/deadletter"
+	"github.com/andreadipersio/efr/event/listener"
+	"github.com/andreadipersio/efr/example"
+)
+
+type ingestionResponse struct {
+	Results []*listener.IngestionResult `json:"results"`
+}
+
+func postEvents(t *testing.T, l *listener.Listener, contentType, body string) (int, *ingestionResponse) {
+	req := httptest.NewRequest("POST", "/events", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, req)
+
+	response := &ingestionResponse{}
+
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(response); err != nil {
+			t.Fatalf("Cannot decode response: %v", err)
+		}
+	}
+
+	return w.Code, response
+}
+
+// connect start l listening on the Unix domain socket at path,
+// and return an EventSource connection to it
+func connect(t *testing.T, l *listener.Listener, path string) net.Conn {
+	go l.Listen()
+
+	var conn net.Conn
+	var err error
+
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", path); err == nil {
+			return conn
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Cannot connect to listener: %v", err)
+
+	return nil
+}
+
+// TestHTTPIngestion prove that events posted over HTTP are numbered on
+// arrival, that each payload outcome is reported and that posting
+// requires server sequencing
+func TestHTTPIngestion(t *testing.T) {
+	dspChan := make(chan event.Event, 10)
+	config := &listener.ResequencerConfig{"stream", 100, 0}
+
+	l := listener.New(nil, dspChan, make(chan interface{}), config, example.NewEvent)
+
+	if code, _ := postEvents(t, l, "text/plain", "1|B\n"); code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 without server sequencing, got %v", code)
+	}
+
+	l.Sequencer, _ = listener.NewSequencer("", 10)
+
+	code, response := postEvents(t, l, "text/plain", "3|B\n2|B\n\nbogus\n")
+
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", code)
+	}
+
+	expected := []bool{true, true, false}
+
+	if len(response.Results) != len(expected) {
+		t.Fatalf("Expected %v results, got %v", len(expected), len(response.Results))
+	}
+
+	for i, accepted := range expected {
+		if r := response.Results[i]; r.Accepted != accepted {
+			t.Fatalf("Payload %v: expected accepted %v, got %+v", r.Payload, accepted, r)
+		}
+	}
+
+	if response.Results[2].Error == "" {
+		t.Fatalf("Expected parse error to be reported")
+	}
+
+	code, response = postEvents(t, l, "application/json", `"1|B"`)
+
+	if code != http.StatusOK || !response.Results[0].Accepted {
+		t.Fatalf("Expected event to be accepted, got %v %+v", code, response.Results)
+	}
+
+	// accepted events are dispatched, numbered in arrival order
+	for seq := 1; seq <= 3; seq++ {
+		if len(dspChan) == 0 {
+			t.Fatalf("Expected event %v to be dispatched", seq)
+		}
+
+		if e := <-dspChan; e.SequenceNum() != seq {
+			t.Fatalf("Expected event %v, got %v", seq, e)
+		}
+	}
+
+	code, response = postEvents(t, l, "application/json", `["4|B", "5|B"]`)
+
+	if code != http.StatusOK || len(response.Results) != 2 || len(dspChan) != 2 {
+		t.Fatalf("Expected a batch of 2 dispatched events, got %v %+v", code, response.Results)
+	}
+
+	if code, _ := postEvents(t, l, "application/json", `{"seq": 6}`); code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for invalid body, got %v", code)
+	}
+
+	if code, _ := postEvents(t, l, "application/xml", `<event/>`); code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected status 415 for unsupported codec, got %v", code)
+	}
+}
+
+// TestDeadLetter prove that unparseable and already dispatched
+// payloads are recorded with the stage which rejected them
+func TestDeadLetter(t *testing.T) {
+	dspChan := make(chan event.Event, 10)
+	ctrlChan := make(chan interface{}, 1)
+	config := &listener.ResequencerConfig{"stream", 100, 0}
+	path := filepath.Join(t.TempDir(), "efr.sock")
+
+	l := listener.New([]string{"unix://" + path}, dspChan, ctrlChan, config, example.NewEvent)
+	l.DeadLetter = deadletter.New(10, nil)
+
+	conn := connect(t, l, path)
+
+	fmt.Fprint(conn, "1|B\nbogus\n1|B\n")
+	conn.Close()
+
+	select {
+	case <-ctrlChan:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for EventSource disconnection")
+	}
+
+	entries := l.DeadLetter.Entries()
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %+v", entries)
+	}
+
+	for i, stage := range []string{deadletter.LISTENER_STAGE, deadletter.RESEQUENCER_STAGE} {
+		if entries[i].Stage != stage || entries[i].Source != "unix" || entries[i].Error == "" {
+			t.Fatalf("Expected dead letter from %v, got %+v", stage, entries[i])
+		}
+	}
+
+	if entries[0].Payload != "bogus" || entries[1].Payload != "1|B" {
+		t.Fatalf("Unexpected dead letter payloads %+v", entries)
+	}
+}
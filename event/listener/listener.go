@@ -6,44 +6,123 @@
 // Once an event is decoded a resequencing strategy is applied,
 // ensuring that outgoing events are sent in the correct order regarding
 // in respect to their sequence ID.
+// Alternatively, when producers cannot stamp a global sequence ID,
+// a Sequencer number events on arrival and resequencing is bypassed.
+// Producers which cannot hold a connection can POST events over HTTP,
+// numbered on arrival by the Sequencer.
 // Once an EventSource disconnect, EventSourceCloseChan is sent a value,
-// which other routines can use to handle event source disconnection.
+// which other routines can use to handle event source disconnection,
+// unless events are numbered by a Sequencer: producers are then
+// uncoordinated, and one of them disconnecting end nothing.
 package listener
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/event/deadletter"
+	"github.com/andreadipersio/efr/netutil"
 )
 
 type Listener struct {
-	Port int
+	// Addresses to listen on, see netutil.ParseAddr
+	Addrs []string
+
+	// Permissions of Unix domain sockets in Addrs
+	Socket *netutil.SocketOptions
 
 	// Resequenced events are sent through this channel
 	DispatchChan chan event.Event
 
-	// Use to inform other routines of EventSource disconnection
+	// Use to inform other routines of EventSource disconnection,
+	// only when events are resequenced
 	EventSourceCloseChan chan interface{}
 
 	ResequencerConfig *ResequencerConfig
 
+	// When not nil, events are numbered on arrival by Sequencer
+	// instead of being resequenced
+	Sequencer *Sequencer
+
 	EventFactory event.EventFactoryType
+
+	// When not nil, EventSource connections are accepted over TLS
+	TLSConfig *tls.Config
+
+	// When not nil, restrict events each EventSource can publish
+	Policy *Policy
+
+	// Events violating Policy are written there when policy
+	// action is QUARANTINE_ACTION
+	Quarantine io.Writer
+
+	// When not nil, rejected payloads are recorded there
+	DeadLetter *deadletter.Sink
+
+	// Address of the HTTP server accepting events on /events,
+	// see ServeHTTP
+	HTTPAddr string
+
+	// protect ResequencerConfig, which can be replaced while listening,
+	// and Quarantine, shared by all EventSource connections
+	mu sync.Mutex
 }
 
-// Listen for incoming connection from EventSource
-func (l *Listener) Listen() {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%v", l.Port))
+// SetResequencerConfig replace resequencer configuration.
+// New configuration is used starting from the next EventSource connection.
+func (l *Listener) SetResequencerConfig(config *ResequencerConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	defer ln.Close()
+	l.ResequencerConfig = config
+}
+
+func (l *Listener) resequencerConfig() *ResequencerConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ResequencerConfig
+}
+
+// Listen for incoming connection from EventSource on every address
+// in Addrs
+func (l *Listener) Listen() {
+	listeners, err := netutil.ListenAll(l.Addrs, l.Socket)
 
 	if err != nil {
 		log.Fatalf("Cannot start Event Listener: %v", err)
 	}
 
-	log.Printf("=== Event Listener waiting for connection on %v", l.Port)
+	var wg sync.WaitGroup
+
+	for _, ln := range listeners {
+		if l.TLSConfig != nil {
+			ln = tls.NewListener(ln, l.TLSConfig)
+		}
+
+		log.Printf("=== Event Listener waiting for connection on %v (TLS %v)", ln.Addr(), l.TLSConfig != nil)
+
+		wg.Add(1)
+
+		go func(ln net.Listener) {
+			defer wg.Done()
+			l.accept(ln)
+		}(ln)
+	}
+
+	wg.Wait()
+}
+
+// accept EventSource connections from ln
+func (l *Listener) accept(ln net.Listener) {
+	defer ln.Close()
 
 	for {
 		conn, err := ln.Accept()
@@ -61,17 +140,42 @@ func (l *Listener) Listen() {
 // batch of events.
 func (l *Listener) handleEventSourceConnection(conn net.Conn) {
 	defer func() {
-		// notify other routines of event source disconnection
-		l.EventSourceCloseChan <- nil
+		// notify other routines of event source disconnection,
+		// other producers may still be connected with server sequencing
+		if l.Sequencer == nil {
+			l.EventSourceCloseChan <- nil
+		}
 
 		// terminate connection with event source
 		conn.Close()
 	}()
 
-	resequencer := NewResequencer(l.ResequencerConfig)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			log.Printf("*** EventSource TLS handshake failed: %v", err)
+			return
+		}
+	}
+
+	source := SourceIdentity(conn)
+
+	var resequencer Resequencer
+	var ordering interface{} = l.Sequencer
+
+	if l.Sequencer == nil {
+		r, err := NewResequencer(l.resequencerConfig())
+
+		if err != nil {
+			log.Printf("Cannot create resequencer: %v", err)
+			return
+		}
+
+		resequencer, ordering = r, r
+	}
+
 	scanner := bufio.NewScanner(conn)
 
-	log.Printf("  = EventSource connected, %s enabled", resequencer)
+	log.Printf("  = EventSource %v connected, %s enabled", source, ordering)
 
 	for scanner.Scan() {
 		if err := scanner.Err(); err != nil {
@@ -79,34 +183,115 @@ func (l *Listener) handleEventSourceConnection(conn net.Conn) {
 			continue
 		}
 
-		payload := scanner.Text()
-		e, err := l.EventFactory(payload)
-
-		if err != nil {
-			log.Printf("Cannot create event: %v", err)
-			continue
+		if err := l.ingest(source, scanner.Text(), resequencer); err != nil {
+			log.Printf("*** Event rejected: %v", err)
 		}
-
-		resequencer.Resequence(e, l.DispatchChan)
 	}
 
 	log.Println("  = EventSource disconnected")
 
 	// send all events in resequencer buffer (guaranted to be sorted)
-	resequencer.Flush(l.DispatchChan)
+	if resequencer != nil {
+		resequencer.Flush(l.DispatchChan)
+	}
 
 	return
 }
 
+// ingest decode payload and send the resulting event through resequencer,
+// or through Sequencer when events are numbered on arrival.
+// Rejected payloads are recorded to DeadLetter.
+func (l *Listener) ingest(source, payload string, resequencer Resequencer) error {
+	e, err := l.EventFactory(payload)
+
+	if err != nil {
+		err = fmt.Errorf("Cannot create event: %v", err)
+		l.reject(deadletter.LISTENER_STAGE, source, payload, err)
+
+		return err
+	}
+
+	if err := l.authorize(source, payload, e); err != nil {
+		if resequencer != nil {
+			resequencer.Discard(e.SequenceNum(), l.DispatchChan)
+		}
+
+		l.reject(deadletter.LISTENER_STAGE, source, payload, err)
+
+		return err
+	}
+
+	if l.Sequencer != nil {
+		if err := l.Sequencer.Dispatch(e, l.DispatchChan); err != nil {
+			l.reject(deadletter.LISTENER_STAGE, source, payload, err)
+			return err
+		}
+
+		return nil
+	}
+
+	if err := resequencer.Resequence(e, l.DispatchChan); err != nil {
+		l.reject(deadletter.RESEQUENCER_STAGE, source, payload, err)
+		return err
+	}
+
+	return nil
+}
+
+// reject record payload to DeadLetter, if any
+func (l *Listener) reject(stage, source, payload string, err error) {
+	if l.DeadLetter != nil {
+		l.DeadLetter.Add(stage, source, payload, err)
+	}
+}
+
+// ListenCommands number events created from client commands with
+// Sequencer, so they share numbering with events from producers
+func (l *Listener) ListenCommands(commandChan chan event.Event) {
+	for e := range commandChan {
+		if err := l.Sequencer.Dispatch(e, l.DispatchChan); err != nil {
+			log.Printf("*** Command event %v dropped: %v", e, err)
+			l.reject(deadletter.LISTENER_STAGE, "", e.String(), err)
+		}
+	}
+}
+
+// authorize check e against Policy, handling violations
+func (l *Listener) authorize(source, payload string, e event.Event) error {
+	if l.Policy == nil {
+		return nil
+	}
+
+	err := l.Policy.Authorize(source, e)
+
+	if err == nil {
+		return nil
+	}
+
+	if l.Policy.Action == QUARANTINE_ACTION && l.Quarantine != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		_, werr := fmt.Fprintf(l.Quarantine, "%v\t%v\t%v\t%v\n",
+			time.Now().UTC().Format(time.RFC3339), source, payload, err)
+
+		if werr != nil {
+			log.Printf("*** Cannot quarantine event %v: %v", e, werr)
+		}
+	}
+
+	return err
+}
+
 func New(
-	port int,
+	addrs []string,
 	dspChan chan event.Event,
 	ctrlChan chan interface{},
 	resequencerConfig *ResequencerConfig,
 	eventFactory event.EventFactoryType,
 ) *Listener {
 	return &Listener{
-		Port:                 port,
+		Addrs:                addrs,
 		DispatchChan:         dspChan,
 		EventSourceCloseChan: ctrlChan,
 		ResequencerConfig:    resequencerConfig,
@@ -0,0 +1,124 @@
+package listener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+const (
+	// Violating events are dropped
+	REJECT_ACTION = "reject"
+
+	// Violating events are dropped and written to the quarantine
+	QUARANTINE_ACTION = "quarantine"
+
+	// Rule for sources without a rule of their own
+	ANY_SOURCE = "*"
+)
+
+// SourceRule list what an EventSource is allowed to publish.
+// An empty list, or a list containing "*", allow anything.
+type SourceRule struct {
+	EventTypes []string `json:"eventTypes"`
+	SenderIDs  []string `json:"senderIDs"`
+}
+
+func allowed(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, allowedValue := range values {
+		if allowedValue == "*" || allowedValue == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Policy restrict, by source identity, which events can be published.
+// Sources without a rule, when there is no ANY_SOURCE rule,
+// cannot publish anything.
+type Policy struct {
+	Sources map[string]*SourceRule `json:"sources"`
+
+	// REJECT_ACTION or QUARANTINE_ACTION
+	Action string `json:"action"`
+}
+
+// Validate return an error if policy action is unknown
+func (p *Policy) Validate() error {
+	switch p.Action {
+	case REJECT_ACTION, QUARANTINE_ACTION:
+		return nil
+	default:
+		return fmt.Errorf("unknown policy action %q, should be '%v' or '%v'",
+			p.Action, REJECT_ACTION, QUARANTINE_ACTION)
+	}
+}
+
+// Authorize return an error if source is not allowed to publish e
+func (p *Policy) Authorize(source string, e event.Event) error {
+	rule, exist := p.Sources[source]
+
+	if !exist {
+		rule, exist = p.Sources[ANY_SOURCE]
+	}
+
+	if !exist {
+		return fmt.Errorf("source %v is not allowed to publish events", source)
+	}
+
+	if !allowed(rule.EventTypes, e.EventType()) {
+		return fmt.Errorf("source %v is not allowed to publish events of type %v", source, e.EventType())
+	}
+
+	if !allowed(rule.SenderIDs, e.SenderID()) {
+		return fmt.Errorf("source %v is not allowed to publish events for sender %v", source, e.SenderID())
+	}
+
+	return nil
+}
+
+// SourceIdentity return the identity of the EventSource connected
+// through conn: the common name of its certificate when using
+// mutual TLS, "unix" for Unix domain sockets, otherwise its IP address.
+func SourceIdentity(conn net.Conn) string {
+	if conn.RemoteAddr().Network() == "unix" {
+		return "unix"
+	}
+
+	var state *tls.ConnectionState
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		cs := tlsConn.ConnectionState()
+		state = &cs
+	}
+
+	return identity(state, conn.RemoteAddr().String())
+}
+
+// HTTPSourceIdentity return the identity of the EventSource
+// which sent r, as SourceIdentity does for connections.
+func HTTPSourceIdentity(r *http.Request) string {
+	return identity(r.TLS, r.RemoteAddr)
+}
+
+func identity(state *tls.ConnectionState, remoteAddr string) string {
+	if state != nil && len(state.PeerCertificates) > 0 {
+		return state.PeerCertificates[0].Subject.CommonName
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
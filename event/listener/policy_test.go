@@ -0,0 +1,70 @@
+package listener_test
+
+import (
+	"testing"
+
+	"github.com/andreadipersio/efr/event/listener"
+	"github.com/andreadipersio/efr/example"
+)
+
+// TestPolicy prove that sources can only publish allowed event types
+// on behalf of allowed senders
+func TestPolicy(t *testing.T) {
+	policy := &listener.Policy{
+		Action: listener.REJECT_ACTION,
+		Sources: map[string]*listener.SourceRule{
+			"producer": &listener.SourceRule{
+				EventTypes: []string{"F", "U"},
+				SenderIDs:  []string{"*"},
+			},
+			"status-service": &listener.SourceRule{
+				EventTypes: []string{"S"},
+				SenderIDs:  []string{"12"},
+			},
+		},
+	}
+
+	type testDataType struct {
+		source    string
+		payload   string
+		isAllowed bool
+	}
+
+	testData := []testDataType{
+		testDataType{"producer", "1|F|12|13", true},
+		testDataType{"producer", "2|U|14|13", true},
+		testDataType{"producer", "3|P|12|13", false},
+
+		testDataType{"status-service", "4|S|12", true},
+		testDataType{"status-service", "5|S|13", false},
+
+		testDataType{"unknown", "6|F|12|13", false},
+	}
+
+	for _, td := range testData {
+		e, _ := example.NewEvent(td.payload)
+
+		err := policy.Authorize(td.source, e)
+
+		if td.isAllowed && err != nil {
+			t.Fatalf("%v should be allowed to publish %v: %v", td.source, td.payload, err)
+		}
+
+		if !td.isAllowed && err == nil {
+			t.Fatalf("%v should not be allowed to publish %v", td.source, td.payload)
+		}
+	}
+
+	// rule for any source
+	policy.Sources[listener.ANY_SOURCE] = &listener.SourceRule{EventTypes: []string{"B"}}
+
+	e, _ := example.NewEvent("7|B")
+
+	if err := policy.Authorize("unknown", e); err != nil {
+		t.Fatalf("Any source should be allowed to broadcast: %v", err)
+	}
+
+	if err := (&listener.Policy{Action: "drop"}).Validate(); err == nil {
+		t.Fatalf("Unknown action should not be valid")
+	}
+}
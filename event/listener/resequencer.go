@@ -10,21 +10,46 @@ import (
 
 type ResequencerConfig struct {
 	// 'stream' or 'batch'
-	Type string
+	Type string `json:"type"`
 
 	// Max size of incoming events queue.
 	// The bigger the value, the bigger the memory consumption.
-	Capacity int
+	Capacity int `json:"capacity"`
 
 	// Start resequencing from SequenceIndex+1
-	SequenceIndex int
+	SequenceIndex int `json:"sequenceIndex"`
+}
+
+// Validate return an error if config does not describe a usable
+// resequencer.
+func (c *ResequencerConfig) Validate() error {
+	switch strings.ToLower(c.Type) {
+	case "batch", "stream":
+	default:
+		return fmt.Errorf("unknown resequencer type %q, should be 'batch' or 'stream'", c.Type)
+	}
+
+	if c.Capacity < 1 {
+		return fmt.Errorf("resequencer capacity should be greater than 0, got %v", c.Capacity)
+	}
+
+	if c.SequenceIndex < 0 {
+		return fmt.Errorf("sequence index cannot be negative, got %v", c.SequenceIndex)
+	}
+
+	return nil
 }
 
 type Resequencer interface {
 	// Resequence append an event to a buffer and based on the
 	// resequencing strategy it check if an ordered sequence of
 	// events can be streamed to the output channel.
-	Resequence(e event.Event, outChan chan event.Event)
+	// Return an error when e is rejected, e.g. already received.
+	Resequence(e event.Event, outChan chan event.Event) error
+
+	// Discard consume sequence number seq without sending any event,
+	// so that following events are not held waiting for it.
+	Discard(seq int, outChan chan event.Event)
 
 	// Send all the remaining events in buffer to outChan.
 	// Sent item are always ordered but sequence may be incomplete.
@@ -35,15 +60,17 @@ type Resequencer interface {
 }
 
 // NewResequencer return the correct resequencer for the choosen type
-// or BatchResequencer if type is wrong.
-func NewResequencer(config *ResequencerConfig) Resequencer {
+// or an error if config is not valid.
+func NewResequencer(config *ResequencerConfig) (Resequencer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	switch strings.ToLower(config.Type) {
 	case "batch":
-		return NewBatchResequencer(config)
-	case "stream":
-		return NewStreamResequencer(config)
+		return NewBatchResequencer(config), nil
 	default:
-		return NewStreamResequencer(config)
+		return NewStreamResequencer(config), nil
 	}
 }
 
@@ -92,14 +119,19 @@ func NewBatchResequencer(config *ResequencerConfig) *BatchResequencer {
 	}
 }
 
-func (r *BatchResequencer) Resequence(e event.Event, dspChan chan event.Event) {
+func (r *BatchResequencer) Resequence(e event.Event, dspChan chan event.Event) error {
 	r.Append(e)
 
 	if r.BufferIsFull() {
 		r.Flush(dspChan)
 	}
+
+	return nil
 }
 
+// Discard is a no-op, batch resequencer never wait for missing events
+func (r *BatchResequencer) Discard(seq int, dspChan chan event.Event) {}
+
 // A Stream resequencer implementation
 type StreamResequencer struct {
 	buffer    map[int]event.Event
@@ -119,7 +151,10 @@ func (r *StreamResequencer) Flush(outChan chan event.Event) {
 	buff := []event.Event{}
 
 	for _, e := range r.buffer {
-		buff = append(buff, e)
+		// skip discarded sequence numbers
+		if e != nil {
+			buff = append(buff, e)
+		}
 	}
 
 	// reset buffer
@@ -147,14 +182,48 @@ func NewStreamResequencer(config *ResequencerConfig) *StreamResequencer {
 // Resequence events in a map and check if an event  with sequence equal
 // to lastindex + 1 exist, if so, then it send it through dspChan and
 // increase lastIndex by 1.
-func (r *StreamResequencer) Resequence(e event.Event, dspChan chan event.Event) {
-	r.buffer[e.SequenceNum()] = e
+// Events whose sequence number has already been sent or buffered
+// are rejected, they would never be sent.
+func (r *StreamResequencer) Resequence(e event.Event, dspChan chan event.Event) error {
+	seq := e.SequenceNum()
+
+	if seq <= r.lastIndex {
+		return fmt.Errorf("Sequence %v already dispatched, last is %v", seq, r.lastIndex)
+	}
+
+	if _, exist := r.buffer[seq]; exist {
+		return fmt.Errorf("Duplicate sequence %v", seq)
+	}
+
+	r.buffer[seq] = e
+	r.send(dspChan)
+
+	return nil
+}
+
+// Discard mark seq as received, without any event to send.
+// Sequence numbers already dispatched or buffered are ignored,
+// so that a rejected event cannot replace a valid one.
+func (r *StreamResequencer) Discard(seq int, dspChan chan event.Event) {
+	if _, exist := r.buffer[seq]; exist || seq <= r.lastIndex {
+		return
+	}
 
+	r.buffer[seq] = nil
+	r.send(dspChan)
+}
+
+// send events from lastIndex + 1 as long as sequence is complete
+func (r *StreamResequencer) send(dspChan chan event.Event) {
 	// Check if we have a valid sequence
 	for {
 		nextSeqNum := r.lastIndex + 1
 		if s, ok := r.buffer[nextSeqNum]; ok {
-			dspChan <- s
+			// nil mark a discarded event
+			if s != nil {
+				dspChan <- s
+			}
+
 			r.lastIndex++
 			delete(r.buffer, nextSeqNum)
 		} else {
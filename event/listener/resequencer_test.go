@@ -1,4 +1,4 @@
-package listener
+package listener_test
 
 import (
 	"fmt"
@@ -83,3 +83,96 @@ func TestStreamResequencer(t *testing.T) {
 
 	testResequencer(t, r, batchSize)
 }
+
+// TestNewResequencerValidation prove that invalid configurations
+// are rejected instead of falling back to a default resequencer
+func TestNewResequencerValidation(t *testing.T) {
+	invalid := []*listener.ResequencerConfig{
+		&listener.ResequencerConfig{"random", 100, 0},
+		&listener.ResequencerConfig{"batch", 0, 0},
+		&listener.ResequencerConfig{"stream", 100, -1},
+	}
+
+	for _, config := range invalid {
+		if r, err := listener.NewResequencer(config); err == nil {
+			t.Fatalf("Expected error for config %+v, got %v", config, r)
+		}
+	}
+
+	if _, err := listener.NewResequencer(&listener.ResequencerConfig{"Batch", 10, 0}); err != nil {
+		t.Fatalf("Should not have failed! %v", err)
+	}
+}
+
+// TestStreamResequencerDiscard prove that discarded sequence numbers
+// do not hold following events, and never replace events already
+// dispatched or buffered
+func TestStreamResequencerDiscard(t *testing.T) {
+	r := listener.NewStreamResequencer(&listener.ResequencerConfig{"stream", 100, 0})
+
+	dspChan := make(chan event.Event, 10)
+
+	for _, payload := range []string{"3|B", "1|B"} {
+		e, _ := example.NewEvent(payload)
+		r.Resequence(e, dspChan)
+	}
+
+	r.Discard(2, dspChan)
+
+	if len(dspChan) != 2 {
+		t.Fatalf("Expected 2 events to be sent, got %v", len(dspChan))
+	}
+
+	if first, second := <-dspChan, <-dspChan; first.SequenceNum() != 1 || second.SequenceNum() != 3 {
+		t.Fatalf("Expected events 1 and 3, got %v and %v", first, second)
+	}
+
+	e, _ := example.NewEvent("5|B")
+	r.Resequence(e, dspChan)
+
+	r.Discard(5, dspChan)
+	r.Discard(1, dspChan)
+
+	e, _ = example.NewEvent("4|B")
+	r.Resequence(e, dspChan)
+
+	if len(dspChan) != 2 {
+		t.Fatalf("Expected 2 events to be sent, got %v", len(dspChan))
+	}
+
+	if first, second := <-dspChan, <-dspChan; first.SequenceNum() != 4 || second.SequenceNum() != 5 {
+		t.Fatalf("Expected events 4 and 5, got %v and %v", first, second)
+	}
+}
+
+// TestStreamResequencerRejection prove that sequence numbers already
+// dispatched or buffered are rejected
+func TestStreamResequencerRejection(t *testing.T) {
+	r := listener.NewStreamResequencer(&listener.ResequencerConfig{"stream", 100, 0})
+
+	dspChan := make(chan event.Event, 10)
+
+	testData := []struct {
+		payload  string
+		accepted bool
+	}{
+		{"1|B", true},
+		{"3|B", true},
+		{"1|B", false},
+		{"3|B", false},
+		{"0|B", false},
+		{"2|B", true},
+	}
+
+	for _, test := range testData {
+		e, _ := example.NewEvent(test.payload)
+
+		if err := r.Resequence(e, dspChan); (err == nil) != test.accepted {
+			t.Fatalf("Event %v: expected accepted %v, got %v", test.payload, test.accepted, err)
+		}
+	}
+
+	if len(dspChan) != 3 {
+		t.Fatalf("Expected 3 events to be sent, got %v", len(dspChan))
+	}
+}
@@ -0,0 +1,173 @@
+package listener
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+const (
+	// Sequence numbers are stamped by producers and events resequenced
+	PRODUCER_SEQUENCING = "producer"
+
+	// Sequence numbers are assigned by the listener on arrival
+	SERVER_SEQUENCING = "server"
+
+	// Sequence numbers reserved on disk at once
+	DefaultSequenceBlockSize = 1000
+)
+
+// Sequencer assign monotonic sequence numbers to events on arrival.
+// To avoid writing to disk for every event, numbers are reserved in
+// blocks of BlockSize: the highest reserved number is persisted, and
+// after a restart numbering continue from the next block, so numbers
+// are never reused, while the unused part of a block is skipped.
+type Sequencer struct {
+	// File where the highest reserved number is stored,
+	// numbering is not persisted when empty
+	Path string
+
+	BlockSize int
+
+	mu sync.Mutex
+
+	// last assigned and highest reserved sequence numbers
+	last     int
+	reserved int
+}
+
+// reserve persist a new block of sequence numbers.
+// Must be called holding mu.
+func (s *Sequencer) reserve() error {
+	reserved := s.reserved + s.BlockSize
+
+	if s.Path != "" {
+		if err := writeSequenceFile(s.Path, reserved); err != nil {
+			return fmt.Errorf("Cannot reserve sequence numbers: %v", err)
+		}
+	}
+
+	s.reserved = reserved
+
+	return nil
+}
+
+// Next return the next sequence number
+func (s *Sequencer) Next() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.next()
+}
+
+func (s *Sequencer) next() (int, error) {
+	if s.last >= s.reserved {
+		if err := s.reserve(); err != nil {
+			return 0, err
+		}
+	}
+
+	s.last++
+
+	return s.last, nil
+}
+
+// Dispatch stamp e with the next sequence number and send it to outChan.
+// Events are sent in the same order they are numbered.
+func (s *Sequencer) Dispatch(e event.Event, outChan chan event.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, err := s.next()
+
+	if err != nil {
+		return err
+	}
+
+	e.SetSequenceNum(seq)
+	outChan <- e
+
+	return nil
+}
+
+func (s *Sequencer) String() string {
+	return fmt.Sprintf("server sequencing from %v", s.last+1)
+}
+
+// readSequenceFile return the number stored at path, 0 if it does not exist
+func readSequenceFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid sequence file %v: %v", path, err)
+	}
+
+	return n, nil
+}
+
+// writeSequenceFile atomically replace the number stored at path
+func writeSequenceFile(path string, n int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintf(tmp, "%v\n", n); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// NewSequencer return a Sequencer continuing the numbering persisted
+// at path, after the last reserved block
+func NewSequencer(path string, blockSize int) (*Sequencer, error) {
+	if blockSize < 1 {
+		return nil, fmt.Errorf("sequence block size should be greater than 0, got %v", blockSize)
+	}
+
+	s := &Sequencer{Path: path, BlockSize: blockSize}
+
+	if path == "" {
+		return s, nil
+	}
+
+	reserved, err := readSequenceFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read sequence file: %v", err)
+	}
+
+	s.last, s.reserved = reserved, reserved
+
+	return s, nil
+}
@@ -0,0 +1,131 @@
+package listener_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/event/listener"
+	"github.com/andreadipersio/efr/example"
+)
+
+// TestSequencer prove that sequence numbers are monotonic, reserved on
+// disk in blocks, and never reused after a restart
+func TestSequencer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequence")
+
+	s, err := listener.NewSequencer(path, 10)
+
+	if err != nil {
+		t.Fatalf("Cannot create sequencer: %v", err)
+	}
+
+	for expected := 1; expected <= 12; expected++ {
+		if seq, err := s.Next(); err != nil || seq != expected {
+			t.Fatalf("Expected sequence %v, got %v (%v)", expected, seq, err)
+		}
+	}
+
+	// two blocks have been reserved
+	if data, _ := ioutil.ReadFile(path); strings.TrimSpace(string(data)) != "20" {
+		t.Fatalf("Expected 20 to be reserved, got '%s'", data)
+	}
+
+	// restart
+	s, err = listener.NewSequencer(path, 10)
+
+	if err != nil {
+		t.Fatalf("Cannot create sequencer: %v", err)
+	}
+
+	if seq, _ := s.Next(); seq != 21 {
+		t.Fatalf("Expected numbering to continue from 21, got %v", seq)
+	}
+
+	ioutil.WriteFile(path, []byte("bogus"), 0600)
+
+	if _, err := listener.NewSequencer(path, 10); err == nil {
+		t.Fatalf("Invalid sequence file should be reported")
+	}
+
+	if _, err := listener.NewSequencer("", 0); err == nil {
+		t.Fatalf("Block size 0 should be rejected")
+	}
+}
+
+// TestServerSequencing prove that events are numbered on arrival,
+// bypassing the resequencer, sharing numbering with client commands
+func TestServerSequencing(t *testing.T) {
+	dspChan := make(chan event.Event, 10)
+	config := &listener.ResequencerConfig{"stream", 100, 0}
+
+	l := listener.New(nil, dspChan, make(chan interface{}), config, example.NewUnsequencedEvent)
+	l.Sequencer, _ = listener.NewSequencer("", 100)
+
+	code, response := postEvents(t, l, "text/plain", "|S|12\n9|B\n")
+
+	if code != http.StatusOK || !response.Results[0].Accepted || !response.Results[1].Accepted {
+		t.Fatalf("Expected events to be accepted, got %v %+v", code, response.Results)
+	}
+
+	for _, expected := range []string{"1|S|12", "2|B"} {
+		if e := <-dspChan; e.String() != expected {
+			t.Fatalf("Expected event %v, got %v", expected, e)
+		}
+	}
+
+	commandChan := make(chan event.Event)
+	go l.ListenCommands(commandChan)
+
+	cmd, _ := example.NewCommandEvent("2", "MSG 1")
+	commandChan <- cmd
+
+	if e := <-dspChan; e.String() != "3|P|2|1" {
+		t.Fatalf("Expected command event 3|P|2|1, got %v", e)
+	}
+
+	postEvents(t, l, "text/plain", "9|P|1|2\n")
+
+	if e := <-dspChan; e.String() != "4|P|1|2" {
+		t.Fatalf("Expected event 4|P|1|2, got %v", e)
+	}
+
+	close(commandChan)
+}
+
+// TestServerSequencingDisconnection prove that, with server sequencing,
+// an EventSource disconnecting is not reported, since other producers
+// may still be connected
+func TestServerSequencingDisconnection(t *testing.T) {
+	dspChan := make(chan event.Event, 10)
+	ctrlChan := make(chan interface{}, 1)
+	config := &listener.ResequencerConfig{"stream", 100, 0}
+	path := filepath.Join(t.TempDir(), "efr.sock")
+
+	l := listener.New([]string{"unix://" + path}, dspChan, ctrlChan, config, example.NewUnsequencedEvent)
+	l.Sequencer, _ = listener.NewSequencer("", 100)
+
+	conn := connect(t, l, path)
+	fmt.Fprint(conn, "|S|12\n")
+	conn.Close()
+
+	select {
+	case e := <-dspChan:
+		if e.String() != "1|S|12" {
+			t.Fatalf("Expected event 1|S|12, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for event")
+	}
+
+	select {
+	case <-ctrlChan:
+		t.Fatalf("EventSource disconnection should not be reported")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
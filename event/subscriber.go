@@ -32,11 +32,52 @@ type Subscriber interface {
 	// type have to be notified
 	GetFollowers() []Subscriber
 	NewFollower(Subscriber)
+	// Remove a follower, or a pending follow request
 	RemoveFollower(string)
+	// Whenever the subscriber with the given ID is a follower
+	IsFollowedBy(string) bool
+
+	// Private subscribers approve followers: follows are kept
+	// as pending requests until accepted or rejected
+	IsPrivate() bool
+	GetFollowRequests() []Subscriber
+	NewFollowRequest(Subscriber)
+
+	// Whenever the subscriber blocked, or muted, the subscriber
+	// with the given ID, so events from it can be withheld
+	IsBlocking(string) bool
+	IsMuting(string) bool
+
+	// Whenever the subscriber has no followers, follow requests,
+	// blocks or mutes and is public, so that it can be dropped and
+	// created again without losing anything
+	IsIsolated() bool
 
 	// Used for initialization of subscriber implementation
 	Init()
 }
 
+// PersistentSubscriber is implemented by subscribers whose state can
+// be saved, so that they can be evicted from memory and restored later.
+// Followers and follow requests are restored using resolve.
+type PersistentSubscriber interface {
+	Subscriber
+
+	Snapshot() ([]byte, error)
+	Restore(state []byte, resolve func(subscriberID string) Subscriber) error
+}
+
+// EntrySubscriber is implemented by subscribers sending events to
+// themselves, such as confirmations. Directories wrapping subscribers,
+// e.g. to filter events sent to them, set the wrapper as entry so that
+// those events go through it too.
+// Subscribers also change and look up their own followers through
+// entry, so that directories can keep followers themselves.
+type EntrySubscriber interface {
+	Subscriber
+
+	SetEntry(Subscriber)
+}
+
 // Given an ID return a subscriber.
 type SubscriberFactoryType func(ID string) Subscriber
@@ -0,0 +1,91 @@
+package subscription
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator verify that a client is entitled to subscribe
+// as subscriberID using credential
+type Authenticator interface {
+	Authenticate(subscriberID, credential string) error
+}
+
+// HMACAuthenticator verify tokens in the format
+//     <expiry unix timestamp>.<hex encoded HMAC-SHA256>
+// where the HMAC is computed over "<subscriberID>|<expiry>"
+// using Secret.
+// Without a Secret every token is rejected, since anyone could sign one.
+type HMACAuthenticator struct {
+	Secret []byte
+}
+
+// ReadSecretFile return the secret stored in the file at path,
+// without surrounding whitespace, which cannot be empty
+func ReadSecretFile(path string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read secret: %v", err)
+	}
+
+	secret = bytes.TrimSpace(secret)
+
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("Secret file %v is empty", path)
+	}
+
+	return secret, nil
+}
+
+func (a *HMACAuthenticator) sign(subscriberID string, expiry int64) string {
+	mac := hmac.New(sha256.New, a.Secret)
+	fmt.Fprintf(mac, "%v|%v", subscriberID, expiry)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Token return a token valid for subscriberID until expiry
+func (a *HMACAuthenticator) Token(subscriberID string, expiry time.Time) string {
+	return fmt.Sprintf("%v.%v", expiry.Unix(), a.sign(subscriberID, expiry.Unix()))
+}
+
+func (a *HMACAuthenticator) Authenticate(subscriberID, credential string) error {
+	if len(a.Secret) == 0 {
+		return ErrUnauthorized
+	}
+
+	parts := strings.SplitN(credential, ".", 2)
+
+	if len(parts) != 2 {
+		return ErrUnauthorized
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	expected := a.sign(subscriberID, expiry)
+
+	if !hmac.Equal([]byte(expected), []byte(parts[1])) {
+		return ErrUnauthorized
+	}
+
+	if time.Now().Unix() > expiry {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
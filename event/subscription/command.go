@@ -0,0 +1,79 @@
+package subscription
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// Commands answered by the subscription server itself, every other
+// command is turned into an event by CommandFactory
+const (
+	PING_COMMAND   = "PING"
+	LOGOUT_COMMAND = "LOGOUT"
+)
+
+// commandErrors count commands which could not be turned into events
+var commandErrors = expvar.NewInt("subscription.commandErrors")
+
+// handleCommand handle a line sent by subscriberID once subscribed,
+// returning the reply to send back (if any) and whether the client
+// logged out
+func (s *SubscriptionServer) handleCommand(subscriberID, line string) (string, bool) {
+	command := strings.TrimSpace(line)
+
+	switch strings.ToUpper(command) {
+	case "":
+		return "", false
+	case PING_COMMAND:
+		return "PONG", false
+	case LOGOUT_COMMAND:
+		return "BYE", true
+	}
+
+	if s.CommandFactory == nil || s.CommandChan == nil {
+		return "ERR commands not supported", false
+	}
+
+	// the sender is always the authenticated subscriber
+	e, err := s.CommandFactory(subscriberID, command)
+
+	if err != nil {
+		commandErrors.Add(1)
+		return fmt.Sprintf("ERR %v", err), false
+	}
+
+	s.CommandChan <- e
+
+	return "", false
+}
+
+// serveCommands read commands sent by subscriberID using readLine,
+// until the client disconnect or log out
+func (s *SubscriptionServer) serveCommands(subscriberID string, readLine func() (string, error), conn io.WriteCloser) {
+	for {
+		line, err := readLine()
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Cannot read command from %v: %v", subscriberID, err)
+			}
+
+			return
+		}
+
+		reply, logout := s.handleCommand(subscriberID, line)
+
+		if reply != "" {
+			fmt.Fprintf(conn, "%v\n", reply)
+		}
+
+		if logout {
+			log.Printf("  = Subscriber %v logged out", subscriberID)
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package subscription
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// authFailures count handshakes rejected by the Authenticator
+var authFailures = expvar.NewInt("subscription.authFailures")
+
+// handshake is the first line sent by a client:
+//     <ID>[ <key>=<value>...]
+// options are used to pass a credential (token=...), a comma
+// separated list of topic patterns to subscribe to (topics=...) and
+// comma separated filters on the events to receive:
+//     types=P,F            only these event types
+//     excludeTypes=S       every event type but these
+//     senders=12,13        only events sent by these subscribers
+//     excludeSenders=99    every event but those sent by these subscribers
+type handshake struct {
+	SubscriberID string
+	Options      map[string]string
+}
+
+func parseHandshake(line string) (*handshake, error) {
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing subscriber ID")
+	}
+
+	h := &handshake{
+		SubscriberID: fields[0],
+		Options:      map[string]string{},
+	}
+
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid handshake option %q", field)
+		}
+
+		h.Options[parts[0]] = parts[1]
+	}
+
+	return h, nil
+}
+
+// authenticate verify handshake credential, if an Authenticator is set
+func (s *SubscriptionServer) authenticate(h *handshake) error {
+	if s.Authenticator == nil {
+		return nil
+	}
+
+	if err := s.Authenticator.Authenticate(h.SubscriberID, h.Options["token"]); err != nil {
+		authFailures.Add(1)
+		log.Printf("*** Authentication failed for subscriber %v: %v", h.SubscriberID, err)
+
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// request return the SubscriptionRequest for a client connected
+// through conn
+func (h *handshake) request(conn io.WriteCloser) (*SubscriptionRequest, error) {
+	subReq := &SubscriptionRequest{SubscriberID: h.SubscriberID, Conn: conn}
+
+	if topics, exist := h.Options["topics"]; exist {
+		for _, pattern := range strings.Split(topics, ",") {
+			if err := event.ValidateTopicPattern(pattern); err != nil {
+				return nil, err
+			}
+
+			subReq.Topics = append(subReq.Topics, pattern)
+		}
+	}
+
+	filter := &event.Filter{
+		Types:          splitOption(h.Options["types"]),
+		ExcludeTypes:   splitOption(h.Options["excludeTypes"]),
+		Senders:        splitOption(h.Options["senders"]),
+		ExcludeSenders: splitOption(h.Options["excludeSenders"]),
+	}
+
+	if !filter.IsEmpty() {
+		subReq.Filter = filter
+	}
+
+	return subReq, nil
+}
+
+// splitOption return values in a comma separated option, ignoring
+// empty ones
+func splitOption(option string) []string {
+	values := []string{}
+
+	for _, v := range strings.Split(option, ",") {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
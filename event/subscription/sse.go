@@ -0,0 +1,329 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of events kept for each SSE subscriber, to be replayed
+	// when reconnecting with Last-Event-ID
+	DefaultSSEHistorySize = 100
+
+	// How long events of a disconnected SSE subscriber are kept
+	DefaultSSEHistoryTTL = 10 * time.Minute
+
+	// Events waiting to be written before a slow SSE client is disconnected
+	sseQueueSize = 64
+)
+
+var errSSEClosed = errors.New("SSE client disconnected")
+
+// sseMessage is an event sent to SSE clients, seq is -1 when
+// the sequence number is unknown
+type sseMessage struct {
+	seq  int
+	data string
+}
+
+func (m *sseMessage) String() string {
+	if m.seq < 0 {
+		return fmt.Sprintf("data: %v\n\n", m.data)
+	}
+
+	return fmt.Sprintf("id: %v\ndata: %v\n\n", m.seq, m.data)
+}
+
+// sseHistory keep last events sent to a subscriber
+type sseHistory struct {
+	mu       sync.Mutex
+	messages []*sseMessage
+	size     int
+
+	// open connections and, when there are none, when the last
+	// one has been closed; protected by SubscriptionServer mu
+	conns    int
+	lastSeen time.Time
+}
+
+func (h *sseHistory) append(m *sseMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.messages = append(h.messages, m)
+
+	if len(h.messages) > h.size {
+		h.messages = h.messages[len(h.messages)-h.size:]
+	}
+}
+
+// since return messages with a sequence number greater than seq
+func (h *sseHistory) since(seq int) []*sseMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	messages := []*sseMessage{}
+
+	for _, m := range h.messages {
+		if m.seq > seq {
+			messages = append(messages, m)
+		}
+	}
+
+	return messages
+}
+
+// sseConn is the io.WriteCloser given to subscribers connected through
+// Server-Sent Events.
+// Events written after the client went away are only kept in history,
+// so they can be replayed when it reconnects, and reported as failed
+// so that the subscriber is known to be disconnected.
+type sseConn struct {
+	server   *SubscriptionServer
+	history  *sseHistory
+	messages chan *sseMessage
+
+	mu     sync.Mutex
+	closed chan struct{}
+	gone   bool
+}
+
+func (c *sseConn) Write(p []byte) (int, error) {
+	data := strings.TrimRight(string(p), "\r\n")
+	m := &sseMessage{seq: -1, data: data}
+
+	if s := c.server; s.EventFactory != nil {
+		if e, err := s.EventFactory(data); err == nil {
+			m.seq = e.SequenceNum()
+		}
+	}
+
+	c.history.append(m)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gone {
+		return 0, errSSEClosed
+	}
+
+	select {
+	case c.messages <- m:
+	default:
+		// slow client, it can catch up reconnecting with Last-Event-ID
+		log.Printf("SSE client too slow, disconnecting")
+		c.gone = true
+		close(c.closed)
+	}
+
+	return len(p), nil
+}
+
+// Close end the event stream
+func (c *sseConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.gone {
+		c.gone = true
+		close(c.closed)
+	}
+
+	return nil
+}
+
+// historyFor return events history of subscriberID, counting
+// a new connection using it until released.
+// Histories of subscribers disconnected for longer than
+// SSEHistoryTTL are dropped.
+func (s *SubscriptionServer) historyFor(subscriberID string) *sseHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sseHistories == nil {
+		s.sseHistories = map[string]*sseHistory{}
+	}
+
+	ttl := s.SSEHistoryTTL
+
+	if ttl == 0 {
+		ttl = DefaultSSEHistoryTTL
+	}
+
+	for id, h := range s.sseHistories {
+		if h.conns == 0 && time.Since(h.lastSeen) > ttl {
+			delete(s.sseHistories, id)
+		}
+	}
+
+	h, exist := s.sseHistories[subscriberID]
+
+	if !exist {
+		size := s.SSEHistorySize
+
+		if size == 0 {
+			size = DefaultSSEHistorySize
+		}
+
+		h = &sseHistory{size: size}
+		s.sseHistories[subscriberID] = h
+	}
+
+	h.conns++
+
+	return h
+}
+
+// releaseHistory record that a connection using h has been closed
+func (s *SubscriptionServer) releaseHistory(h *sseHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.conns--
+	h.lastSeen = time.Now()
+}
+
+// ServeSSE accept subscriptions on GET /subscribe/{id} and stream
+// events using Server-Sent Events, with the event sequence number
+// as SSE id.
+// Handshake options are passed as query parameters, the token can
+// also be sent as an "Authorization: Bearer" header.
+// When reconnecting with a Last-Event-ID header, events with a greater
+// sequence number sent meanwhile are replayed first.
+func (s *SubscriptionServer) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subscriberID := strings.TrimPrefix(r.URL.Path, "/subscribe/")
+
+	if subscriberID == "" || strings.ContainsAny(subscriberID, "/ \t") {
+		http.NotFound(w, r)
+		return
+	}
+
+	h := &handshake{SubscriberID: subscriberID, Options: map[string]string{}}
+
+	for key, values := range r.URL.Query() {
+		h.Options[key] = values[0]
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		h.Options["token"] = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if err := s.authenticate(h); err != nil {
+		http.Error(w, fmt.Sprintf("ERR %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	c := &sseConn{
+		server:   s,
+		history:  s.historyFor(subscriberID),
+		messages: make(chan *sseMessage, sseQueueSize),
+		closed:   make(chan struct{}),
+	}
+
+	defer s.releaseHistory(c.history)
+
+	subReq, err := h.request(c)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ERR %v", err), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastSeq := -1
+
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		seq, err := strconv.Atoi(lastEventID)
+
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+
+		lastSeq = seq
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Once the dispatcher received the request, events are written
+	// to c, so everything sent before is already in history
+	s.SubscriptionChan <- subReq
+
+	write := func(m *sseMessage) bool {
+		if _, err := fmt.Fprint(w, m); err != nil {
+			return false
+		}
+
+		flusher.Flush()
+
+		return true
+	}
+
+	if lastSeq >= 0 {
+		for _, m := range c.history.since(lastSeq) {
+			if !write(m) {
+				c.Close()
+				return
+			}
+
+			lastSeq = m.seq
+		}
+	}
+
+	interval := s.PingInterval
+
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m := <-c.messages:
+			// already replayed
+			if m.seq >= 0 && m.seq <= lastSeq {
+				continue
+			}
+
+			if !write(m) {
+				c.Close()
+				return
+			}
+		case <-ticker.C:
+			// comment line, keep proxies from closing the connection
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				c.Close()
+				return
+			}
+
+			flusher.Flush()
+		case <-r.Context().Done():
+			c.Close()
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
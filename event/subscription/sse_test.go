@@ -0,0 +1,226 @@
+package subscription_test
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreadipersio/efr/event/subscription"
+	"github.com/andreadipersio/efr/example"
+)
+
+// sseClient read SSE messages from a streaming response
+type sseClient struct {
+	resp   *http.Response
+	reader *bufio.Reader
+}
+
+func getSSE(t *testing.T, url, lastEventID string) *sseClient {
+	req, _ := http.NewRequest("GET", url, nil)
+
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatalf("Cannot connect to %v: %v", url, err)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %v %v", resp.Status, ct)
+	}
+
+	return &sseClient{resp, bufio.NewReader(resp.Body)}
+}
+
+// next return the next message, without its trailing blank line
+func (c *sseClient) next(t *testing.T) string {
+	lines := []string{}
+
+	result := make(chan error, 1)
+
+	go func() {
+		for {
+			line, err := c.reader.ReadString('\n')
+
+			if err != nil {
+				result <- err
+				return
+			}
+
+			if line == "\n" {
+				result <- nil
+				return
+			}
+
+			lines = append(lines, strings.TrimRight(line, "\n"))
+		}
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("Cannot read SSE message: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for SSE message")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// serveSSE return a test server for s, and a channel receiving
+// a value every time a client stream is closed
+func serveSSE(s *subscription.SubscriptionServer) (*httptest.Server, chan struct{}) {
+	closed := make(chan struct{}, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeSSE(w, r)
+		closed <- struct{}{}
+	}))
+
+	return server, closed
+}
+
+// waitClosed wait for the server to notice that a client went away
+func waitClosed(t *testing.T, closed chan struct{}) {
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for SSE stream to be closed")
+	}
+}
+
+func receiveRequest(t *testing.T, subChan chan *subscription.SubscriptionRequest) *subscription.SubscriptionRequest {
+	select {
+	case subReq := <-subChan:
+		return subReq
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for subscription request")
+	}
+
+	return nil
+}
+
+// TestSSESubscription prove that events written to SSE subscribers are
+// streamed with their sequence number as id, and that reconnecting with
+// Last-Event-ID replay events sent meanwhile
+func TestSSESubscription(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(nil, subChan)
+	s.EventFactory = example.NewEvent
+
+	server, closed := serveSSE(s)
+	defer server.Close()
+
+	client := getSSE(t, server.URL+"/subscribe/123", "")
+	subReq := receiveRequest(t, subChan)
+
+	if subReq.SubscriberID != "123" {
+		t.Fatalf("Expected ID 123 got '%v'", subReq.SubscriberID)
+	}
+
+	fmt.Fprintf(subReq.Conn, "%v\n", "1|F|12|123")
+
+	if m := client.next(t); m != "id: 1\ndata: 1|F|12|123" {
+		t.Fatalf("Unexpected SSE message '%v'", m)
+	}
+
+	client.resp.Body.Close()
+
+	// events sent while the client is away are kept, but reported
+	// as failed so that the subscriber is disconnected
+	waitClosed(t, closed)
+
+	if _, err := fmt.Fprintf(subReq.Conn, "%v\n", "2|P|12|123"); err == nil {
+		t.Fatalf("Writing to a disconnected SSE client should fail")
+	}
+
+	fmt.Fprintf(subReq.Conn, "%v\n", "3|P|13|123")
+
+	client = getSSE(t, server.URL+"/subscribe/123", "1")
+	defer client.resp.Body.Close()
+
+	subReq = receiveRequest(t, subChan)
+
+	for _, expected := range []string{"id: 2\ndata: 2|P|12|123", "id: 3\ndata: 3|P|13|123"} {
+		if m := client.next(t); m != expected {
+			t.Fatalf("Expected replayed message '%v', got '%v'", expected, m)
+		}
+	}
+
+	fmt.Fprintf(subReq.Conn, "%v\n", "4|S|123")
+
+	if m := client.next(t); m != "id: 4\ndata: 4|S|123" {
+		t.Fatalf("Unexpected SSE message '%v'", m)
+	}
+}
+
+// TestSSEHistoryExpiry prove that events of subscribers disconnected
+// for longer than SSEHistoryTTL are not kept
+func TestSSEHistoryExpiry(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(nil, subChan)
+	s.EventFactory = example.NewEvent
+	s.SSEHistoryTTL = 10 * time.Millisecond
+
+	server, closed := serveSSE(s)
+	defer server.Close()
+
+	client := getSSE(t, server.URL+"/subscribe/123", "")
+	subReq := receiveRequest(t, subChan)
+
+	fmt.Fprintf(subReq.Conn, "%v\n", "1|F|12|123")
+	client.next(t)
+	client.resp.Body.Close()
+
+	waitClosed(t, closed)
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprintf(subReq.Conn, "%v\n", "2|P|12|123")
+
+	client = getSSE(t, server.URL+"/subscribe/123", "1")
+	defer client.resp.Body.Close()
+
+	subReq = receiveRequest(t, subChan)
+	fmt.Fprintf(subReq.Conn, "%v\n", "3|S|123")
+
+	if m := client.next(t); m != "id: 3\ndata: 3|S|123" {
+		t.Fatalf("Expired events should not be replayed, got '%v'", m)
+	}
+}
+
+// TestSSERejection prove that SSE subscriptions are authenticated
+func TestSSERejection(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(nil, subChan)
+
+	authenticator := &subscription.HMACAuthenticator{Secret: []byte("secret")}
+	s.Authenticator = authenticator
+
+	server := httptest.NewServer(http.HandlerFunc(s.ServeSSE))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/subscribe/123?token=bogus")
+
+	if err != nil {
+		t.Fatalf("Cannot connect: %v", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %v", resp.Status)
+	}
+
+	token := authenticator.Token("123", time.Now().Add(time.Minute))
+	client := getSSE(t, server.URL+"/subscribe/123?token="+token, "")
+	defer client.resp.Body.Close()
+
+	receiveRequest(t, subChan)
+}
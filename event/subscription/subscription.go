@@ -1,16 +1,34 @@
 // subscription package implement a subscription service.
 // Client connect to the servive and should send a unique ID as a
-// 'CRLF' terminated string.
+// 'CRLF' terminated string, optionally followed by space separated
+// key=value options:
+//     123 token=1700000000.4f2a... topics=sports.*,news types=P,F
+// When an Authenticator is set, the token option is verified before
+// subscribing the client; rejected clients receive "ERR unauthorized"
+// and are disconnected.
+// Once subscribed, clients can send one command per line: PING is
+// answered with PONG, LOGOUT close the connection, other commands
+// (e.g. FOLLOW 56) are turned into events by CommandFactory and sent
+// through CommandChan, to be dispatched like events from producers.
+// Browsers can subscribe through WebSocket, served on HTTPAddr at /ws,
+// or through Server-Sent Events at /subscribe/{id}.
 // Each subscription request is then routed back to a receiver listening
 // on SubscriptionChan, which receive the SubscriberID and it's tcp connection.
 package subscription
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/netutil"
 )
 
 // SubscriptionRequest associate a client identified by an ID
@@ -19,42 +37,136 @@ import (
 type SubscriptionRequest struct {
 	SubscriberID string
 	Conn         io.WriteCloser
+
+	// Topic patterns to subscribe to, from the topics handshake option
+	Topics []string
+
+	// Restrict events sent to the subscriber, nil to receive everything
+	Filter *event.Filter
 }
 
 // Subscription server listen for client connection
 // and broadcast them through SubscriptionChan has SubscriptionRequest
 type SubscriptionServer struct {
-	Port             int
+	// Addresses to listen on, see netutil.ParseAddr
+	Addrs []string
+
+	// Permissions of Unix domain sockets in Addrs and HTTPAddr
+	Socket *netutil.SocketOptions
+
 	SubscriptionChan chan *SubscriptionRequest
+
+	// When not nil, client connections are accepted over TLS
+	TLSConfig *tls.Config
+
+	// When not nil, clients must provide a valid credential
+	Authenticator Authenticator
+
+	// Address of the HTTP server accepting WebSocket subscriptions
+	HTTPAddr string
+
+	// How often WebSocket clients are pinged, DefaultPingInterval if 0
+	PingInterval time.Duration
+
+	// Used to recover sequence numbers of events sent to SSE clients
+	EventFactory event.EventFactoryType
+
+	// Events kept for SSE replay, DefaultSSEHistorySize if 0
+	SSEHistorySize int
+
+	// How long events are kept for SSE replay once a subscriber
+	// disconnected, DefaultSSEHistoryTTL if 0
+	SSEHistoryTTL time.Duration
+
+	// Turn commands sent by subscribed clients into events,
+	// when nil clients can only PING and LOGOUT
+	CommandFactory event.CommandFactoryType
+
+	// Events created from client commands are sent there
+	CommandChan chan event.Event
+
+	// protect sseHistories
+	mu           sync.Mutex
+	sseHistories map[string]*sseHistory
+}
+
+// reject notify client of a failed handshake and close connection
+func reject(conn net.Conn, err error) {
+	fmt.Fprintf(conn, "ERR %v\n", err)
+	conn.Close()
 }
 
-func (s *SubscriptionServer) handleSubscriptionRequest(conn *net.TCPConn) {
-	ID, err := bufio.NewReader(conn).ReadString('\n')
-	ID = ID[:len(ID)-1]
+func (s *SubscriptionServer) handleSubscriptionRequest(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	payload, err := reader.ReadString('\n')
 
 	if err != nil {
 		log.Printf("Cannot read payload: %v", err)
+		conn.Close()
 		return
 	}
 
-	s.SubscriptionChan <- &SubscriptionRequest{ID, conn}
-}
+	h, err := parseHandshake(payload)
 
-func (s *SubscriptionServer) Listen() {
-	addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%v", s.Port))
+	if err != nil {
+		log.Printf("Invalid handshake from %v: %v", conn.RemoteAddr(), err)
+		reject(conn, err)
+		return
+	}
 
-	ln, err := net.ListenTCP("tcp", addr)
+	if err := s.authenticate(h); err != nil {
+		reject(conn, err)
+		return
+	}
 
-	defer ln.Close()
+	subReq, err := h.request(conn)
+
+	if err != nil {
+		reject(conn, err)
+		return
+	}
+
+	s.SubscriptionChan <- subReq
+
+	s.serveCommands(h.SubscriberID, func() (string, error) {
+		return reader.ReadString('\n')
+	}, conn)
+}
+
+// Listen for client connections on every address in Addrs
+func (s *SubscriptionServer) Listen() {
+	listeners, err := netutil.ListenAll(s.Addrs, s.Socket)
 
 	if err != nil {
 		log.Fatalf("*** Cannot start Subscription server: %v", err)
 	}
 
-	log.Printf("=== Subscription server listening to %v", s.Port)
+	var wg sync.WaitGroup
+
+	for _, ln := range listeners {
+		if s.TLSConfig != nil {
+			ln = tls.NewListener(ln, s.TLSConfig)
+		}
+
+		log.Printf("=== Subscription server listening to %v (TLS %v)", ln.Addr(), s.TLSConfig != nil)
+
+		wg.Add(1)
+
+		go func(ln net.Listener) {
+			defer wg.Done()
+			s.accept(ln)
+		}(ln)
+	}
+
+	wg.Wait()
+}
+
+// accept client connections from ln
+func (s *SubscriptionServer) accept(ln net.Listener) {
+	defer ln.Close()
 
 	for {
-		conn, err := ln.AcceptTCP()
+		conn, err := ln.Accept()
 
 		if err != nil {
 			log.Printf("Cannot read from socket: %v", err)
@@ -65,9 +177,32 @@ func (s *SubscriptionServer) Listen() {
 	}
 }
 
-func New(port int, subscriptionChan chan *SubscriptionRequest) *SubscriptionServer {
+// ListenHTTP accept subscriptions through HTTP based transports on HTTPAddr
+func (s *SubscriptionServer) ListenHTTP() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.ServeWebSocket)
+	mux.HandleFunc("/subscribe/", s.ServeSSE)
+
+	ln, err := netutil.Listen(s.HTTPAddr, s.Socket)
+
+	if err != nil {
+		log.Fatalf("*** Cannot start Subscription HTTP server: %v", err)
+	}
+
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+
+	log.Printf("=== Subscription HTTP server listening to %v (TLS %v)", s.HTTPAddr, s.TLSConfig != nil)
+
+	if err := http.Serve(ln, mux); err != nil {
+		log.Fatalf("*** Subscription HTTP server stopped: %v", err)
+	}
+}
+
+func New(addrs []string, subscriptionChan chan *SubscriptionRequest) *SubscriptionServer {
 	return &SubscriptionServer{
-		Port:             port,
+		Addrs:            addrs,
 		SubscriptionChan: subscriptionChan,
 	}
 }
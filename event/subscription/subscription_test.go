@@ -1,42 +1,238 @@
-package subscription
+package subscription_test
 
 import (
+	"bufio"
+	"expvar"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andreadipersio/efr/event"
 	"github.com/andreadipersio/efr/event/subscription"
+	"github.com/andreadipersio/efr/example"
 )
 
+// dial connect to addr, retrying until server is listening
+func dial(t *testing.T, addr string) net.Conn {
+	deadline := time.Now().Add(time.Second)
+
+	for {
+		conn, err := net.Dial("tcp", addr)
+
+		if err == nil {
+			return conn
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Cannot connect to %v: %v", addr, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // TestSubscription prove that subscription server can accept connection
-// on a port and create a subscriptionRequest which wil be routed through
-// a channel
+// on every configured address and create a subscriptionRequest which
+// wil be routed through a channel
 func TestSubscription(t *testing.T) {
 	// setup server
-	port := 11111
-	addr := fmt.Sprintf("localhost:%v", port)
+	addrs := []string{"localhost:11111", "127.0.0.1:11113"}
+
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(addrs, subChan)
+
+	go s.Listen()
+
+	for _, addr := range addrs {
+		// setup client
+		conn := dial(t, addr)
+
+		testSubscriberID := "123"
+
+		// send request
+		fmt.Fprintf(conn, fmt.Sprintf("%v\n", testSubscriberID))
+
+		// verify that SubscriptionRequest has been correctly created
+		subReq := <-subChan
+
+		if subReq.SubscriberID != testSubscriberID {
+			t.Fatalf("Expected ID %v got '%v'", testSubscriberID, subReq.SubscriberID)
+		}
+	}
+}
+
+// TestAuthenticatedSubscription prove that when an authenticator is set
+// only clients sending a valid token are subscribed
+func TestAuthenticatedSubscription(t *testing.T) {
+	addr := "localhost:11112"
+
+	authenticator := &subscription.HMACAuthenticator{Secret: []byte("secret")}
 
 	subChan := make(chan *subscription.SubscriptionRequest)
-	s := subscription.New(port, subChan)
+	s := subscription.New([]string{addr}, subChan)
+	s.Authenticator = authenticator
 
 	go s.Listen()
 
-	// setup client
-	conn, err := net.Dial("tcp", addr)
+	failures := expvar.Get("subscription.authFailures").(*expvar.Int).Value()
+
+	// token signed for someone else
+	conn := dial(t, addr)
+	token := authenticator.Token("456", time.Now().Add(time.Minute))
+
+	fmt.Fprintf(conn, "123 token=%v\r\n", token)
+
+	response, _ := bufio.NewReader(conn).ReadString('\n')
+
+	if response != "ERR unauthorized\n" {
+		t.Fatalf("Expected rejection, got '%v'", response)
+	}
 
-	if err != nil {
-		t.Fatalf("Cannot connect to %v: %v", addr, err)
+	if v := expvar.Get("subscription.authFailures").(*expvar.Int).Value(); v != failures+1 {
+		t.Fatalf("Expected %v authentication failures, got %v", failures+1, v)
 	}
 
-	testSubscriberID := "123"
+	// valid token
+	conn = dial(t, addr)
+	token = authenticator.Token("123", time.Now().Add(time.Minute))
+
+	fmt.Fprintf(conn, "123 token=%v\r\n", token)
+
+	select {
+	case subReq := <-subChan:
+		if subReq.SubscriberID != "123" {
+			t.Fatalf("Expected ID 123 got '%v'", subReq.SubscriberID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for subscription request")
+	}
+}
 
-	// send request
-	fmt.Fprintf(conn, fmt.Sprintf("%v\n", testSubscriberID))
+// TestCommands prove that commands sent once subscribed are answered
+// or turned into events sent by the subscriber
+func TestCommands(t *testing.T) {
+	addr := "localhost:11114"
+
+	subChan := make(chan *subscription.SubscriptionRequest, 1)
+	cmdChan := make(chan event.Event, 1)
+
+	s := subscription.New([]string{addr}, subChan)
+	s.CommandFactory = example.NewCommandEvent
+	s.CommandChan = cmdChan
+
+	go s.Listen()
+
+	conn := dial(t, addr)
+	reader := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "123\n")
+
+	expectReply := func(command, expected string) {
+		fmt.Fprintf(conn, "%v\n", command)
+
+		if reply, _ := reader.ReadString('\n'); reply != expected+"\n" {
+			t.Fatalf("Expected reply '%v' to %v, got '%v'", expected, command, reply)
+		}
+	}
+
+	expectReply("PING", "PONG")
+	expectReply("JUMP", "ERR Unknown command JUMP")
+
+	fmt.Fprintf(conn, "FOLLOW 56\n")
+
+	select {
+	case e := <-cmdChan:
+		if e.EventType() != "F" || e.SenderID() != "123" || e.RecipientID() != "56" {
+			t.Fatalf("Unexpected command event %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for command event")
+	}
+
+	expectReply("LOGOUT", "BYE")
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Fatalf("Connection should be closed after LOGOUT")
+	}
+}
+
+// TestHandshakeOptions prove that topics and filters passed at handshake
+// are forwarded with the subscription request, and invalid topics rejected
+func TestHandshakeOptions(t *testing.T) {
+	addr := "localhost:11115"
+
+	subChan := make(chan *subscription.SubscriptionRequest, 1)
+	s := subscription.New([]string{addr}, subChan)
+
+	go s.Listen()
+
+	conn := dial(t, addr)
+	fmt.Fprintf(conn, "123 topics=sports.*,news types=P,F excludeSenders=99\n")
+
+	select {
+	case subReq := <-subChan:
+		if len(subReq.Topics) != 2 || subReq.Topics[0] != "sports.*" || subReq.Topics[1] != "news" {
+			t.Fatalf("Unexpected topics %v", subReq.Topics)
+		}
+
+		f := subReq.Filter
+
+		if f == nil || len(f.Types) != 2 || len(f.ExcludeSenders) != 1 || len(f.Senders) != 0 {
+			t.Fatalf("Unexpected filter %+v", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for subscription request")
+	}
+
+	conn = dial(t, addr)
+	fmt.Fprintf(conn, "123 topics=sports..football\n")
+
+	if response, _ := bufio.NewReader(conn).ReadString('\n'); !strings.HasPrefix(response, "ERR ") {
+		t.Fatalf("Expected rejection, got '%v'", response)
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	a := &subscription.HMACAuthenticator{Secret: []byte("secret")}
+
+	if err := a.Authenticate("1", a.Token("1", time.Now().Add(time.Minute))); err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+
+	if err := a.Authenticate("1", a.Token("1", time.Now().Add(-time.Minute))); err == nil {
+		t.Fatalf("Expired token accepted")
+	}
+
+	other := &subscription.HMACAuthenticator{Secret: []byte("other")}
+
+	if err := a.Authenticate("1", other.Token("1", time.Now().Add(time.Minute))); err == nil {
+		t.Fatalf("Token signed with another secret accepted")
+	}
+
+	if err := a.Authenticate("1", ""); err == nil {
+		t.Fatalf("Empty token accepted")
+	}
+
+	unsigned := &subscription.HMACAuthenticator{}
+
+	if err := unsigned.Authenticate("1", unsigned.Token("1", time.Now().Add(time.Minute))); err == nil {
+		t.Fatalf("Token signed without a secret accepted")
+	}
+
+	path := filepath.Join(t.TempDir(), "secret")
+	ioutil.WriteFile(path, []byte(" \n"), 0600)
+
+	if _, err := subscription.ReadSecretFile(path); err == nil {
+		t.Fatalf("Empty secret file accepted")
+	}
 
-	// verify that SubscriptionRequest has been correctly created
-	subReq := <-subChan
+	ioutil.WriteFile(path, []byte("secret\n"), 0600)
 
-	if subReq.SubscriberID != testSubscriberID {
-		t.Fatalf("Expected ID %v got '%v'", testSubscriberID, subReq.SubscriberID)
+	if secret, err := subscription.ReadSecretFile(path); err != nil || string(secret) != "secret" {
+		t.Fatalf("Expected secret 'secret', got '%s' %v", secret, err)
 	}
 }
@@ -0,0 +1,382 @@
+package subscription
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RFC 6455 key used to compute Sec-WebSocket-Accept
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// Largest client message we accept
+	maxWebSocketPayload = 64 * 1024
+
+	DefaultPingInterval = 30 * time.Second
+)
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	closeNormal          = 1000
+	closeProtocolError   = 1002
+	closePolicyViolation = 1008
+	closeTooBig          = 1009
+)
+
+var errPayloadTooBig = errors.New("websocket payload too big")
+
+// wsFrame is a decoded WebSocket frame
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame read a masked frame sent by a client
+func readFrame(r *bufio.Reader) (*wsFrame, error) {
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	f := &wsFrame{
+		fin:    header[0]&0x80 != 0,
+		opcode: header[0] & 0x0F,
+	}
+
+	if header[1]&0x80 == 0 {
+		return nil, fmt.Errorf("client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+
+		length = binary.BigEndian.Uint64(ext)
+	}
+
+	if length > maxWebSocketPayload {
+		return nil, errPayloadTooBig
+	}
+
+	mask := make([]byte, 4)
+
+	if _, err := io.ReadFull(r, mask); err != nil {
+		return nil, err
+	}
+
+	f.payload = make([]byte, length)
+
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+
+	return f, nil
+}
+
+// writeFrame write an unmasked, final frame as required for servers
+func writeFrame(w io.Writer, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode}
+
+	switch length := len(payload); {
+	case length < 126:
+		header = append(header, byte(length))
+	case length <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(length))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(length))
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+
+	return err
+}
+
+func closePayload(code uint16, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+
+	return append(payload, reason...)
+}
+
+// wsConn is the io.WriteCloser given to subscribers connected through
+// WebSocket. Every Write is sent as a text message.
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// serialize frames written by subscriber, pings and control replies
+	mu sync.Mutex
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// last time a pong (or any other frame) has been received
+	lastSeen time.Time
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return writeFrame(c.conn, opcode, payload)
+}
+
+// Write send p, without its trailing newline, as a text message
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opText, bytes.TrimRight(p, "\r\n")); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close send a close frame and close the underlying connection
+func (c *wsConn) Close() error {
+	return c.closeWithStatus(closeNormal, "")
+}
+
+func (c *wsConn) closeWithStatus(code uint16, reason string) error {
+	var err error
+
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, closePayload(code, reason))
+		close(c.closed)
+		err = c.conn.Close()
+	})
+
+	return err
+}
+
+// readMessage return next text or binary message, replying to
+// control frames while waiting for it
+func (c *wsConn) readMessage() ([]byte, error) {
+	message := []byte{}
+
+	for {
+		f, err := readFrame(c.reader)
+
+		if err == errPayloadTooBig {
+			c.closeWithStatus(closeTooBig, err.Error())
+			return nil, err
+		}
+
+		if err != nil {
+			c.closeWithStatus(closeProtocolError, "")
+			return nil, err
+		}
+
+		c.mu.Lock()
+		c.lastSeen = time.Now()
+		c.mu.Unlock()
+
+		switch f.opcode {
+		case opPing:
+			c.writeFrame(opPong, f.payload)
+		case opPong:
+		case opClose:
+			c.closeWithStatus(closeNormal, "")
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+			message = append(message, f.payload...)
+
+			if len(message) > maxWebSocketPayload {
+				c.closeWithStatus(closeTooBig, errPayloadTooBig.Error())
+				return nil, errPayloadTooBig
+			}
+
+			if f.fin {
+				return message, nil
+			}
+		default:
+			c.closeWithStatus(closeProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("unknown opcode %v", f.opcode)
+		}
+	}
+}
+
+// keepalive ping the client every interval, closing the connection
+// if nothing has been received for two intervals
+func (c *wsConn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			idle := time.Since(c.lastSeen)
+			c.mu.Unlock()
+
+			if idle > 2*interval {
+				log.Printf("WebSocket client %v timed out", c.conn.RemoteAddr())
+				c.closeWithStatus(closePolicyViolation, "ping timeout")
+				return
+			}
+
+			c.writeFrame(opPing, nil)
+		}
+	}
+}
+
+// upgrade perform the WebSocket opening handshake, hijacking
+// the HTTP connection
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a WebSocket upgrade request")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported WebSocket version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response cannot be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha1.New()
+	io.WriteString(h, key+webSocketGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %v\r\n\r\n", accept)
+
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{
+		conn:     conn,
+		reader:   rw.Reader,
+		closed:   make(chan struct{}),
+		lastSeen: time.Now(),
+	}, nil
+}
+
+// ServeWebSocket accept subscriptions over WebSocket.
+// First message sent by the client is the handshake, in the same
+// format used on the TCP port. Once subscribed, every event is
+// received as a text message, and commands are sent one per message.
+func (s *SubscriptionServer) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	c, err := upgrade(w, r)
+
+	if err != nil {
+		log.Printf("Cannot upgrade to WebSocket: %v", err)
+		return
+	}
+
+	payload, err := c.readMessage()
+
+	if err != nil {
+		log.Printf("Cannot read WebSocket handshake: %v", err)
+		return
+	}
+
+	h, err := parseHandshake(string(payload))
+
+	if err == nil {
+		err = s.authenticate(h)
+	}
+
+	var subReq *SubscriptionRequest
+
+	if err == nil {
+		subReq, err = h.request(c)
+	}
+
+	if err != nil {
+		c.Write([]byte(fmt.Sprintf("ERR %v", err)))
+		c.closeWithStatus(closePolicyViolation, err.Error())
+		return
+	}
+
+	interval := s.PingInterval
+
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+
+	go c.keepalive(interval)
+
+	s.SubscriptionChan <- subReq
+
+	// keep reading commands, answering control frames meanwhile
+	s.serveCommands(h.SubscriberID, func() (string, error) {
+		message, err := c.readMessage()
+
+		return string(message), err
+	}, c)
+}
@@ -0,0 +1,190 @@
+package subscription_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreadipersio/efr/event/subscription"
+)
+
+// wsClient is a minimal WebSocket client
+type wsClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server) *wsClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+
+	if err != nil {
+		t.Fatalf("Cannot connect to %v: %v", server.URL, err)
+	}
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read upgrade response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %v", resp.Status)
+	}
+
+	// RFC 6455 sample key and accept value
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Wrong Sec-WebSocket-Accept: %v", accept)
+	}
+
+	return &wsClient{conn, reader}
+}
+
+// send a masked frame
+func (c *wsClient) send(opcode byte, payload string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+
+	c.conn.Write(frame)
+}
+
+// receive an unmasked frame
+func (c *wsClient) receive(t *testing.T) (byte, string) {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		t.Fatalf("Cannot read frame: %v", err)
+	}
+
+	length := int(header[1] & 0x7F)
+
+	if length == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(c.reader, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+
+	payload := make([]byte, length)
+	io.ReadFull(c.reader, payload)
+
+	return header[0] & 0x0F, string(payload)
+}
+
+// TestWebSocketSubscription prove that a WebSocket client can subscribe
+// and receive events written by the dispatcher as text messages
+func TestWebSocketSubscription(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(nil, subChan)
+
+	server := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer server.Close()
+
+	client := dialWebSocket(t, server)
+	client.send(0x1, "123")
+
+	var subReq *subscription.SubscriptionRequest
+
+	select {
+	case subReq = <-subChan:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for subscription request")
+	}
+
+	if subReq.SubscriberID != "123" {
+		t.Fatalf("Expected ID 123 got '%v'", subReq.SubscriberID)
+	}
+
+	// the dispatcher write events terminated by a newline
+	fmt.Fprintf(subReq.Conn, "%v\n", "1|B")
+
+	if opcode, payload := client.receive(t); opcode != 0x1 || payload != "1|B" {
+		t.Fatalf("Expected text message '1|B', got opcode %v '%v'", opcode, payload)
+	}
+
+	// ping is answered with a pong carrying the same payload
+	client.send(0x9, "hello")
+
+	if opcode, payload := client.receive(t); opcode != 0xA || payload != "hello" {
+		t.Fatalf("Expected pong 'hello', got opcode %v '%v'", opcode, payload)
+	}
+
+	// closing from the client is acknowledged
+	client.send(0x8, "")
+
+	if opcode, _ := client.receive(t); opcode != 0x8 {
+		t.Fatalf("Expected close frame, got opcode %v", opcode)
+	}
+
+	if _, err := subReq.Conn.Write([]byte("2|B\n")); err == nil {
+		t.Fatalf("Writing to a closed WebSocket should fail")
+	}
+}
+
+// TestWebSocketRejection prove that WebSocket handshakes are authenticated
+func TestWebSocketRejection(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest)
+	s := subscription.New(nil, subChan)
+	s.Authenticator = &subscription.HMACAuthenticator{Secret: []byte("secret")}
+
+	server := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer server.Close()
+
+	client := dialWebSocket(t, server)
+	client.send(0x1, "123 token=bogus")
+
+	if opcode, payload := client.receive(t); opcode != 0x1 || payload != "ERR unauthorized" {
+		t.Fatalf("Expected rejection, got opcode %v '%v'", opcode, payload)
+	}
+
+	if opcode, _ := client.receive(t); opcode != 0x8 {
+		t.Fatalf("Expected close frame, got opcode %v", opcode)
+	}
+}
+
+// TestWebSocketKeepalive prove that clients are pinged and disconnected
+// when they stop answering
+func TestWebSocketKeepalive(t *testing.T) {
+	subChan := make(chan *subscription.SubscriptionRequest, 1)
+	s := subscription.New(nil, subChan)
+	s.PingInterval = 20 * time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(s.ServeWebSocket))
+	defer server.Close()
+
+	client := dialWebSocket(t, server)
+	client.send(0x1, "123")
+
+	if opcode, _ := client.receive(t); opcode != 0x9 {
+		t.Fatalf("Expected ping, got opcode %v", opcode)
+	}
+
+	// never answer, expect to be disconnected
+	for {
+		opcode, _ := client.receive(t)
+
+		if opcode == 0x8 {
+			break
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Separate topic segments, e.g. sports.football
+	TopicSeparator = "."
+
+	// Match exactly one topic segment, e.g. sports.*
+	TopicWildcard = "*"
+)
+
+// ValidateTopic return an error if name cannot be used as a topic
+// events are published to
+func ValidateTopic(name string) error {
+	if err := ValidateTopicPattern(name); err != nil {
+		return err
+	}
+
+	for _, segment := range strings.Split(name, TopicSeparator) {
+		if segment == TopicWildcard {
+			return fmt.Errorf("cannot publish to topic pattern %q", name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateTopicPattern return an error if pattern cannot be used to
+// subscribe to topics
+func ValidateTopicPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("empty topic")
+	}
+
+	if strings.ContainsAny(pattern, "|, \t\r\n") {
+		return fmt.Errorf("invalid character in topic %q", pattern)
+	}
+
+	for _, segment := range strings.Split(pattern, TopicSeparator) {
+		if segment == "" {
+			return fmt.Errorf("empty segment in topic %q", pattern)
+		}
+
+		if segment != TopicWildcard && strings.Contains(segment, TopicWildcard) {
+			return fmt.Errorf("wildcard must be a whole segment in topic %q", pattern)
+		}
+	}
+
+	return nil
+}
+
+// MatchTopic report whenever topic match pattern, where each wildcard
+// segment match exactly one topic segment:
+//     sports.* match sports.football, not sports or sports.football.uk
+func MatchTopic(pattern, topic string) bool {
+	patternSegments := strings.Split(pattern, TopicSeparator)
+	topicSegments := strings.Split(topic, TopicSeparator)
+
+	if len(patternSegments) != len(topicSegments) {
+		return false
+	}
+
+	for i, segment := range patternSegments {
+		if segment != TopicWildcard && segment != topicSegments[i] {
+			return false
+		}
+	}
+
+	return true
+}
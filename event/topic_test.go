@@ -0,0 +1,63 @@
+package event_test
+
+import (
+	"testing"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+func TestMatchTopic(t *testing.T) {
+	type testDataType struct {
+		pattern, topic string
+		match          bool
+	}
+
+	testData := []testDataType{
+		testDataType{"news", "news", true},
+		testDataType{"sports.*", "sports.football", true},
+		testDataType{"*.football", "sports.football", true},
+		testDataType{"sports.*.uk", "sports.football.uk", true},
+
+		testDataType{"news", "sports", false},
+		testDataType{"sports.*", "sports", false},
+		testDataType{"sports.*", "sports.football.uk", false},
+		testDataType{"sports.football", "sports.*", false},
+	}
+
+	for _, td := range testData {
+		if match := event.MatchTopic(td.pattern, td.topic); match != td.match {
+			t.Fatalf("Matching %v against %v: expected %v, got %v", td.topic, td.pattern, td.match, match)
+		}
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	type testDataType struct {
+		name               string
+		isTopic, isPattern bool
+	}
+
+	testData := []testDataType{
+		testDataType{"news", true, true},
+		testDataType{"sports.football", true, true},
+		testDataType{"sports.*", false, true},
+		testDataType{"*", false, true},
+
+		testDataType{"", false, false},
+		testDataType{"sports.", false, false},
+		testDataType{"sports..uk", false, false},
+		testDataType{"sports.foot*", false, false},
+		testDataType{"a|b", false, false},
+		testDataType{"a,b", false, false},
+	}
+
+	for _, td := range testData {
+		if err := event.ValidateTopic(td.name); (err == nil) != td.isTopic {
+			t.Fatalf("Topic %q: expected valid %v, got %v", td.name, td.isTopic, err)
+		}
+
+		if err := event.ValidateTopicPattern(td.name); (err == nil) != td.isPattern {
+			t.Fatalf("Topic pattern %q: expected valid %v, got %v", td.name, td.isPattern, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package example
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/andreadipersio/efr/event"
+)
+
+// Commands a connected client can send, each one produce an event
+// whose sender is the client:
+//      FOLLOW <ID>        'F' Follow
+//      UNFOLLOW <ID>      'U' Unfollow
+//      MSG <ID>           'P' Private Message
+//      STATUS             'S' Status Update
+//      CLOSE              'SC' Close Friends Status Update
+//      PUB <topic>        'T' Publish to topic
+//      SUB <pattern>      'TS' Subscribe to topics matching pattern
+//      UNSUB <pattern>    'TU' Unsubscribe from topics matching pattern
+//      GROUP <group>      'GC' Create group
+//      JOIN <group>       'GJ' Join group
+//      LEAVE <group>      'GL' Leave group
+//      GMSG <group>       'GM' Send a message to group members
+//      BLOCK <ID>         'BK' Block
+//      UNBLOCK <ID>       'UB' Unblock
+//      MUTE <ID>          'MT' Mute
+//      UNMUTE <ID>        'UM' Unmute
+//      PRIVATE            'PV' Approve followers from now on
+//      PUBLIC             'PB' Stop approving followers
+//      ACCEPT <ID>        'FA' Accept a follow request
+//      DECLINE <ID>       'FD' Reject a follow request
+const (
+	FOLLOW_COMMAND          = "FOLLOW"
+	UNFOLLOW_COMMAND        = "UNFOLLOW"
+	PRIVATE_MESSAGE_COMMAND = "MSG"
+	STATUS_UPDATE_COMMAND   = "STATUS"
+	CLOSE_FRIENDS_COMMAND   = "CLOSE"
+	PUBLISH_COMMAND         = "PUB"
+	SUBSCRIBE_COMMAND       = "SUB"
+	UNSUBSCRIBE_COMMAND     = "UNSUB"
+	GROUP_CREATE_COMMAND    = "GROUP"
+	GROUP_JOIN_COMMAND      = "JOIN"
+	GROUP_LEAVE_COMMAND     = "LEAVE"
+	GROUP_MESSAGE_COMMAND   = "GMSG"
+	BLOCK_COMMAND           = "BLOCK"
+	UNBLOCK_COMMAND         = "UNBLOCK"
+	MUTE_COMMAND            = "MUTE"
+	UNMUTE_COMMAND          = "UNMUTE"
+	PRIVATE_COMMAND         = "PRIVATE"
+	PUBLIC_COMMAND          = "PUBLIC"
+	FOLLOW_ACCEPT_COMMAND   = "ACCEPT"
+	FOLLOW_DECLINE_COMMAND  = "DECLINE"
+)
+
+// validateRecipient return an error if a subscriber cannot send
+// an event to recipientID
+func validateRecipient(subscriberID, recipientID string) error {
+	if recipientID == subscriberID || strings.ContainsAny(recipientID, fieldDelimiter+recipientDelimiter) {
+		return fmt.Errorf("Invalid recipient %v", recipientID)
+	}
+
+	return nil
+}
+
+func validateGroup(subscriberID, groupID string) error {
+	if strings.ContainsAny(groupID, fieldDelimiter+recipientDelimiter) {
+		return fmt.Errorf("Invalid group %v", groupID)
+	}
+
+	return nil
+}
+
+func validateTopic(subscriberID, topic string) error {
+	return event.ValidateTopic(topic)
+}
+
+func validateTopicPattern(subscriberID, pattern string) error {
+	return event.ValidateTopicPattern(pattern)
+}
+
+// commandETypes map commands to the event type they produce and,
+// for commands taking an argument, how to validate it
+var commandETypes = map[string]struct {
+	eType    string
+	validate func(subscriberID, arg string) error
+}{
+	FOLLOW_COMMAND:          {FOLLOW_ETYPE, validateRecipient},
+	UNFOLLOW_COMMAND:        {UNFOLLOW_ETYPE, validateRecipient},
+	PRIVATE_MESSAGE_COMMAND: {PRIVATE_MESSAGE_ETYPE, validateRecipient},
+	STATUS_UPDATE_COMMAND:   {STATUS_UPDATE_ETYPE, nil},
+	CLOSE_FRIENDS_COMMAND:   {CLOSE_FRIENDS_ETYPE, nil},
+	PUBLISH_COMMAND:         {event.TOPIC_ETYPE, validateTopic},
+	SUBSCRIBE_COMMAND:       {event.TOPIC_SUBSCRIBE_ETYPE, validateTopicPattern},
+	UNSUBSCRIBE_COMMAND:     {event.TOPIC_UNSUBSCRIBE_ETYPE, validateTopicPattern},
+	GROUP_CREATE_COMMAND:    {event.GROUP_CREATE_ETYPE, validateGroup},
+	GROUP_JOIN_COMMAND:      {event.GROUP_JOIN_ETYPE, validateGroup},
+	GROUP_LEAVE_COMMAND:     {event.GROUP_LEAVE_ETYPE, validateGroup},
+	GROUP_MESSAGE_COMMAND:   {event.GROUP_MESSAGE_ETYPE, validateGroup},
+	BLOCK_COMMAND:           {BLOCK_ETYPE, validateRecipient},
+	UNBLOCK_COMMAND:         {UNBLOCK_ETYPE, validateRecipient},
+	MUTE_COMMAND:            {MUTE_ETYPE, validateRecipient},
+	UNMUTE_COMMAND:          {UNMUTE_ETYPE, validateRecipient},
+	PRIVATE_COMMAND:         {PRIVATE_ETYPE, nil},
+	PUBLIC_COMMAND:          {PUBLIC_ETYPE, nil},
+	FOLLOW_ACCEPT_COMMAND:   {FOLLOW_ACCEPT_ETYPE, validateRecipient},
+	FOLLOW_DECLINE_COMMAND:  {FOLLOW_DECLINE_ETYPE, validateRecipient},
+}
+
+// NewCommandEvent return the event produced by command sent by
+// subscriberID, its sequence number is left to 0.
+func NewCommandEvent(subscriberID, command string) (event.Event, error) {
+	fields := strings.Fields(command)
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Empty command")
+	}
+
+	name := strings.ToUpper(fields[0])
+	c, exist := commandETypes[name]
+
+	if !exist {
+		return nil, fmt.Errorf("Unknown command %v", fields[0])
+	}
+
+	args := fields[1:]
+
+	if c.validate != nil && len(args) != 1 {
+		return nil, fmt.Errorf("%v require an argument", name)
+	}
+
+	if c.validate == nil && len(args) != 0 {
+		return nil, fmt.Errorf("%v does not take arguments", name)
+	}
+
+	e := &Event{
+		eType:    c.eType,
+		senderID: subscriberID,
+	}
+
+	if c.validate != nil {
+		if err := c.validate(subscriberID, args[0]); err != nil {
+			return nil, err
+		}
+
+		e.recipientID = args[0]
+	}
+
+	return e, nil
+}
@@ -10,6 +10,9 @@ import (
 
 const (
 	fieldDelimiter = "|"
+
+	// Separate recipients of multicast events, e.g. 1|M|12|13,14
+	recipientDelimiter = event.RECIPIENT_DELIMITER
 )
 
 type Event struct {
@@ -23,6 +26,12 @@ type Event struct {
 //     123|S|56
 // and return an Event
 func (e *Event) Parse(s string) error {
+	return e.parse(s, false)
+}
+
+// parse read s as Parse do, sequence can be empty (|S|56) when
+// unsequenced is true, since the server will number the event
+func (e *Event) parse(s string, unsequenced bool) error {
 	parts := strings.Split(s, fieldDelimiter)
 
 	validateFieldNumber := func(fields []string) error {
@@ -37,13 +46,16 @@ func (e *Event) Parse(s string) error {
 		return err
 	}
 
-	sequenceNum, err := strconv.Atoi(parts[0])
+	// sequence is omitted when assigned by the server
+	if parts[0] != "" || !unsequenced {
+		sequenceNum, err := strconv.Atoi(parts[0])
 
-	if err != nil {
-		return fmt.Errorf("Invalid sequence: %v", err)
-	}
+		if err != nil {
+			return fmt.Errorf("Invalid sequence: %v", err)
+		}
 
-	e.sequence = sequenceNum
+		e.sequence = sequenceNum
+	}
 	e.eType = parts[1]
 
 	if len(parts) > 2 {
@@ -54,6 +66,14 @@ func (e *Event) Parse(s string) error {
 		e.recipientID = parts[3]
 	}
 
+	if e.eType == event.MULTICAST_ETYPE && e.recipientID != "" {
+		for _, recipientID := range strings.Split(e.recipientID, recipientDelimiter) {
+			if recipientID == "" {
+				return fmt.Errorf("Empty recipient in %v", e.recipientID)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -61,6 +81,10 @@ func (e *Event) SequenceNum() int {
 	return e.sequence
 }
 
+func (e *Event) SetSequenceNum(sequence int) {
+	e.sequence = sequence
+}
+
 func (e *Event) SenderID() string {
 	return e.senderID
 }
@@ -69,6 +93,20 @@ func (e *Event) RecipientID() string {
 	return e.recipientID
 }
 
+// RecipientIDs return recipients in the comma separated recipient field
+// of multicast events, the recipient of other events
+func (e *Event) RecipientIDs() []string {
+	if e.recipientID == "" {
+		return []string{}
+	}
+
+	if e.eType != event.MULTICAST_ETYPE {
+		return []string{e.recipientID}
+	}
+
+	return strings.Split(e.recipientID, recipientDelimiter)
+}
+
 func (e *Event) EventType() string {
 	return e.eType
 }
@@ -98,3 +136,15 @@ func NewEvent(s string) (event.Event, error) {
 		return nil, err
 	}
 }
+
+// NewUnsequencedEvent is NewEvent for events numbered by the server,
+// whose sequence can be empty
+func NewUnsequencedEvent(s string) (event.Event, error) {
+	e := &Event{}
+
+	if err := e.parse(s, true); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
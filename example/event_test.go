@@ -1,7 +1,8 @@
-package example
+package example_test
 
 import (
 	"log"
+	"reflect"
 	"testing"
 
 	"github.com/andreadipersio/efr/example"
@@ -20,8 +21,12 @@ func TestParse(t *testing.T) {
 		testDataType{"1|B", true},
 		testDataType{"150|F|12|13", true},
 		testDataType{"22|S|12", true},
+		testDataType{"|S|12", false},
+		testDataType{"3|M|12|13,14", true},
 
 		testDataType{"A|S|15", false},
+		testDataType{"3|M|12|13,,14", false},
+		testDataType{"3|M|12|13,", false},
 		testDataType{"", false},
 
 		testDataType{"2|S|123|13|53", true},
@@ -42,6 +47,23 @@ func TestParse(t *testing.T) {
 	}
 }
 
+// TestNewUnsequencedEvent prove that only events numbered by the
+// server can omit their sequence
+func TestNewUnsequencedEvent(t *testing.T) {
+	for payload, isValid := range map[string]bool{
+		"|S|12":   true,
+		"22|S|12": true,
+		"A|S|12":  false,
+		"12":      false,
+	} {
+		e, err := example.NewUnsequencedEvent(payload)
+
+		if isValid != (err == nil) {
+			t.Fatalf("Parsing %v: expected valid %v, got %v (%v)", payload, isValid, e, err)
+		}
+	}
+}
+
 // TestString prove that Event.String return the same
 // string it got when parsing when invoked.
 func TestString(t *testing.T) {
@@ -54,6 +76,7 @@ func TestString(t *testing.T) {
 		testDataType{"1|B", true},
 		testDataType{"150|F|12|13", true},
 		testDataType{"22|S|12", true},
+		testDataType{"3|M|12|13,14", true},
 	}
 
 	for _, testEvent := range testEvents {
@@ -68,3 +91,79 @@ func TestString(t *testing.T) {
 		}
 	}
 }
+
+// TestRecipientIDs prove that only multicast events list several
+// recipients
+func TestRecipientIDs(t *testing.T) {
+	for payload, expected := range map[string][]string{
+		"3|M|12|13,14": {"13", "14"},
+		"3|P|12|13,14": {"13,14"},
+		"3|S|12":       {},
+	} {
+		e, _ := example.NewEvent(payload)
+
+		if r := e.RecipientIDs(); !reflect.DeepEqual(r, expected) {
+			t.Fatalf("Expected recipients of %v to be %v, got %v", payload, expected, r)
+		}
+	}
+}
+
+// TestNewCommandEvent prove that client commands are turned into
+// events sent by the client, and that malformed commands are rejected
+func TestNewCommandEvent(t *testing.T) {
+	type testDataType struct {
+		command  string
+		expected string
+		isValid  bool
+	}
+
+	testCommands := []testDataType{
+		testDataType{"FOLLOW 13", "7|F|12|13", true},
+		testDataType{"unfollow 13", "7|U|12|13", true},
+		testDataType{"MSG 13", "7|P|12|13", true},
+		testDataType{"STATUS", "7|S|12", true},
+		testDataType{"PUB sports.football", "7|T|12|sports.football", true},
+		testDataType{"SUB sports.*", "7|TS|12|sports.*", true},
+		testDataType{"UNSUB sports.*", "7|TU|12|sports.*", true},
+		testDataType{"GROUP friends", "7|GC|12|friends", true},
+		testDataType{"gmsg friends", "7|GM|12|friends", true},
+		testDataType{"BLOCK 13", "7|BK|12|13", true},
+		testDataType{"UNMUTE 13", "7|UM|12|13", true},
+		testDataType{"PRIVATE", "7|PV|12", true},
+		testDataType{"ACCEPT 13", "7|FA|12|13", true},
+
+		testDataType{"", "", false},
+		testDataType{"JUMP", "", false},
+		testDataType{"FOLLOW", "", false},
+		testDataType{"FOLLOW 12", "", false},
+		testDataType{"FOLLOW 13|B", "", false},
+		testDataType{"STATUS 13", "", false},
+		testDataType{"PUB sports.*", "", false},
+		testDataType{"SUB sports..football", "", false},
+		testDataType{"SUB", "", false},
+		testDataType{"MSG 13,14", "", false},
+		testDataType{"JOIN a,b", "", false},
+	}
+
+	for _, td := range testCommands {
+		e, err := example.NewCommandEvent("12", td.command)
+
+		if !td.isValid {
+			if err == nil {
+				t.Fatalf("Command %q should fail! Got event %v", td.command, e)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Command %q should succeed! Got error: %v", td.command, err)
+		}
+
+		e.SetSequenceNum(7)
+
+		if e.String() != td.expected {
+			t.Fatalf("Command %q: expected event %v, got %v", td.command, td.expected, e)
+		}
+	}
+}
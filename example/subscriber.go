@@ -1,18 +1,36 @@
 // example package implement subscriber.Subscriber interface
 // using User data type.
 
-// Supported events:
+// Supported events, who is notified of them is set by DeliveryRules:
 //      'F' Follow: Add event source to event recipient follower list,
-//                  notify recipient
-//      'U' Unfollow: Remove event source from event recipient follower list
+//                  or to its follow requests when private, notify recipient
+//      'U' Unfollow: Remove event source from event recipient follower list,
+//                    or cancel its follow request
 //      'P' Private Message: Notify event recipient of a new private message
 //      'S' Status Update: Notify all followers of event source
+//      'SC' Close Friends Status Update: Notify followers which
+//                                         event source follows back
+// Events sent by blocked users are never delivered to the blocker.
+//      'BK' Block: Event recipient cannot follow, message or send status
+//                  updates to event source anymore, its follow is removed
+//      'UB' Unblock: Remove a block
+//      'MT' Mute: Hide status updates of event recipient from event source,
+//                 keeping the follow
+//      'UM' Unmute: Remove a mute
+//      'PV' Private: Event source approve its followers from now on
+//      'PB' Public: Event source accept pending follow requests and
+//                   new followers without approval
+//      'FA' Follow Accept: Event recipient follow request is accepted,
+//                          notify recipient
+//      'FD' Follow Decline: Event recipient follow request is rejected
 package example
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"sort"
 
 	"github.com/andreadipersio/efr/event"
 )
@@ -22,12 +40,101 @@ const (
 	UNFOLLOW_ETYPE        = "U"
 	PRIVATE_MESSAGE_ETYPE = "P"
 	STATUS_UPDATE_ETYPE   = "S"
+	CLOSE_FRIENDS_ETYPE   = "SC"
+	BLOCK_ETYPE           = "BK"
+	UNBLOCK_ETYPE         = "UB"
+	MUTE_ETYPE            = "MT"
+	UNMUTE_ETYPE          = "UM"
+	PRIVATE_ETYPE         = "PV"
+	PUBLIC_ETYPE          = "PB"
+	FOLLOW_ACCEPT_ETYPE   = "FA"
+	FOLLOW_DECLINE_ETYPE  = "FD"
 )
 
+// DefaultDeliveryRules notify the recipient of follows, private messages
+// and accepted follow requests, followers of status updates and mutual
+// followers of close friends status updates.
+// Other events, unfollows included, are not delivered.
+var DefaultDeliveryRules = event.DeliveryRules{
+	FOLLOW_ETYPE:          {event.DELIVER_RECIPIENT},
+	PRIVATE_MESSAGE_ETYPE: {event.DELIVER_RECIPIENT},
+	STATUS_UPDATE_ETYPE:   {event.DELIVER_FOLLOWERS},
+	CLOSE_FRIENDS_ETYPE:   {event.DELIVER_MUTUALS},
+	FOLLOW_ACCEPT_ETYPE:   {event.DELIVER_RECIPIENT},
+}
+
+// EventTypes list events handled by User, see dispatcher.Dispatcher
+var EventTypes = map[string]bool{
+	FOLLOW_ETYPE:          true,
+	UNFOLLOW_ETYPE:        true,
+	PRIVATE_MESSAGE_ETYPE: true,
+	STATUS_UPDATE_ETYPE:   true,
+	CLOSE_FRIENDS_ETYPE:   true,
+	BLOCK_ETYPE:           true,
+	UNBLOCK_ETYPE:         true,
+	MUTE_ETYPE:            true,
+	UNMUTE_ETYPE:          true,
+	PRIVATE_ETYPE:         true,
+	PUBLIC_ETYPE:          true,
+	FOLLOW_ACCEPT_ETYPE:   true,
+	FOLLOW_DECLINE_ETYPE:  true,
+}
+
+// recipientETypes list events which are meaningless without a recipient
+var recipientETypes = map[string]bool{
+	FOLLOW_ETYPE:          true,
+	UNFOLLOW_ETYPE:        true,
+	PRIVATE_MESSAGE_ETYPE: true,
+	BLOCK_ETYPE:           true,
+	UNBLOCK_ETYPE:         true,
+	MUTE_ETYPE:            true,
+	UNMUTE_ETYPE:          true,
+	FOLLOW_ACCEPT_ETYPE:   true,
+	FOLLOW_DECLINE_ETYPE:  true,
+}
+
+// DefaultNetworkHops limit network delivery to followers of followers
+const DefaultNetworkHops = 2
+
 type User struct {
 	id        string
 	followers map[string]event.Subscriber
 	conn      io.WriteCloser
+
+	// who is notified of events sent by this user
+	rules event.DeliveryRules
+
+	// how far from this user network delivery reach
+	networkHops int
+
+	// IDs of users blocked and muted by this user
+	blocked map[string]bool
+	muted   map[string]bool
+
+	// Private users keep follows waiting for approval there
+	private  bool
+	requests map[string]event.Subscriber
+
+	// events and follows this user handle itself go through entry,
+	// see SetEntry
+	entry event.Subscriber
+}
+
+// SetEntry make events this user send to itself, e.g. unfollow
+// confirmations, and changes to and lookups of its own followers,
+// e.g. accepted follow requests, go through entry, such as its
+// directory entry
+func (u *User) SetEntry(entry event.Subscriber) {
+	u.entry = entry
+}
+
+// self return the subscriber u is reached through, see SetEntry
+func (u *User) self() event.Subscriber {
+	if u.entry != nil {
+		return u.entry
+	}
+
+	return u
 }
 
 func (u *User) Connect(c io.WriteCloser) {
@@ -37,6 +144,7 @@ func (u *User) Connect(c io.WriteCloser) {
 func (u *User) Disconnect() {
 	if u.conn != nil {
 		u.conn.Close()
+		u.conn = nil
 	}
 }
 
@@ -53,30 +161,112 @@ func (u *User) SetID(ID string) {
 }
 
 func (sender *User) HandleEvent(e event.Event, recipient event.Subscriber) error {
+	if recipientETypes[e.EventType()] && recipient.GetID() == "" {
+		return fmt.Errorf("Event %v has no recipient", e)
+	}
+
 	switch e.EventType() {
 	// Unfollow
 	case UNFOLLOW_ETYPE:
 		recipient.RemoveFollower(e.SenderID())
 
-	// Follow
+	// Follow, silently ignored when blocked
 	case FOLLOW_ETYPE:
-		recipient.NewFollower(sender)
-		recipient.SendEvent(e)
+		if recipient.IsBlocking(sender.id) {
+			return nil
+		}
 
-	// Private message
+		if recipient.IsPrivate() {
+			recipient.NewFollowRequest(sender)
+		} else {
+			recipient.NewFollower(sender)
+		}
+
+	// Private message, silently ignored when blocked
 	case PRIVATE_MESSAGE_ETYPE:
-		recipient.SendEvent(e)
+		if recipient.IsBlocking(sender.id) {
+			return nil
+		}
 
-	// Status Update
-	case STATUS_UPDATE_ETYPE:
-		sender.followersBroadcast(e)
+	// Status Updates, only delivered
+	case STATUS_UPDATE_ETYPE, CLOSE_FRIENDS_ETYPE:
+
+	// Block
+	case BLOCK_ETYPE:
+		sender.blocked[recipient.GetID()] = true
+		sender.self().RemoveFollower(recipient.GetID())
+
+	case UNBLOCK_ETYPE:
+		delete(sender.blocked, recipient.GetID())
+
+	// Mute
+	case MUTE_ETYPE:
+		sender.muted[recipient.GetID()] = true
+
+	case UNMUTE_ETYPE:
+		delete(sender.muted, recipient.GetID())
+
+	case PRIVATE_ETYPE:
+		sender.private = true
+
+	// Becoming public, pending requests are accepted
+	case PUBLIC_ETYPE:
+		sender.private = false
+
+		for requesterID, requester := range sender.requests {
+			delete(sender.requests, requesterID)
+			sender.self().NewFollower(requester)
+		}
+
+	// Follow request accepted
+	case FOLLOW_ACCEPT_ETYPE:
+		requester, exist := sender.requests[recipient.GetID()]
+
+		if !exist {
+			return fmt.Errorf("No follow request from %v to %v", recipient.GetID(), sender.id)
+		}
+
+		delete(sender.requests, recipient.GetID())
+		sender.self().NewFollower(requester)
+
+	// Follow request rejected
+	case FOLLOW_DECLINE_ETYPE:
+		if _, exist := sender.requests[recipient.GetID()]; !exist {
+			return fmt.Errorf("No follow request from %v to %v", recipient.GetID(), sender.id)
+		}
+
+		delete(sender.requests, recipient.GetID())
 	default:
 		return fmt.Errorf("Unsupported event %v", e)
 	}
 
+	sender.deliver(e, recipient)
+
 	return nil
 }
 
+// deliver notify e to those listed in delivery rules for its type
+func (sender *User) deliver(e event.Event, recipient event.Subscriber) {
+	delivery := sender.rules[e.EventType()]
+
+	if delivery.Has(event.DELIVER_SENDER) {
+		sender.self().SendEvent(e)
+	}
+
+	if delivery.Has(event.DELIVER_RECIPIENT) && recipient.GetID() != "" && !recipient.IsBlocking(sender.id) {
+		recipient.SendEvent(e)
+	}
+
+	switch {
+	case delivery.Has(event.DELIVER_NETWORK):
+		sender.networkBroadcast(e)
+	case delivery.Has(event.DELIVER_FOLLOWERS):
+		sender.followersBroadcast(e)
+	case delivery.Has(event.DELIVER_MUTUALS):
+		sender.mutualsBroadcast(e)
+	}
+}
+
 func (u *User) SendEvent(e event.Event) {
 	// user is not connected, ignore silently
 	if u.conn == nil {
@@ -87,6 +277,9 @@ func (u *User) SendEvent(e event.Event) {
 
 	if err != nil {
 		log.Printf("*** Cannot send notification %v: %v", e, err)
+
+		// client went away, stop writing to it
+		u.Disconnect()
 	}
 }
 
@@ -110,23 +303,216 @@ func (u *User) NewFollower(follower event.Subscriber) {
 
 func (u *User) RemoveFollower(followerID string) {
 	delete(u.followers, followerID)
+	delete(u.requests, followerID)
+}
+
+func (u *User) IsFollowedBy(subscriberID string) bool {
+	_, exist := u.followers[subscriberID]
+
+	return exist
+}
+
+func (u *User) IsPrivate() bool {
+	return u.private
+}
+
+func (u *User) GetFollowRequests() []event.Subscriber {
+	requests := []event.Subscriber{}
+
+	for _, r := range u.requests {
+		requests = append(requests, r)
+	}
+
+	return requests
+}
+
+func (u *User) NewFollowRequest(follower event.Subscriber) {
+	u.requests[follower.GetID()] = follower
 }
 
+func (u *User) IsBlocking(subscriberID string) bool {
+	return u.blocked[subscriberID]
+}
+
+func (u *User) IsMuting(subscriberID string) bool {
+	return u.muted[subscriberID]
+}
+
+// accept report whenever s is connected and want to receive status
+// updates of u, disconnected subscribers are checked first since they
+// may have to be reloaded to answer the others
+func (u *User) accept(s event.Subscriber) bool {
+	return s.IsConnected() && !s.IsBlocking(u.id) && !s.IsMuting(u.id)
+}
+
+// followersBroadcast send e to followers, except those which
+// blocked or muted u
 func (u *User) followersBroadcast(e event.Event) {
-	for _, follower := range u.GetFollowers() {
-		follower.SendEvent(e)
+	for _, follower := range u.self().GetFollowers() {
+		if u.accept(follower) {
+			follower.SendEvent(e)
+		}
+	}
+}
+
+// mutualsBroadcast send e to followers which u follows back
+func (u *User) mutualsBroadcast(e event.Event) {
+	for _, follower := range u.self().GetFollowers() {
+		if u.accept(follower) && follower.IsFollowedBy(u.id) {
+			follower.SendEvent(e)
+		}
 	}
 }
 
+// networkBroadcast send e once to followers, followers of followers
+// and so on, up to networkHops away from u.
+// Updates of private users only reach their approved followers.
+func (u *User) networkBroadcast(e event.Event) {
+	hops := u.networkHops
+
+	if u.private {
+		hops = 1
+	}
+
+	visited := map[string]bool{u.id: true}
+	frontier := []event.Subscriber{u.self()}
+
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		next := []event.Subscriber{}
+
+		for _, s := range frontier {
+			for _, follower := range s.GetFollowers() {
+				if visited[follower.GetID()] {
+					continue
+				}
+
+				visited[follower.GetID()] = true
+				next = append(next, follower)
+
+				if u.accept(follower) {
+					follower.SendEvent(e)
+				}
+			}
+		}
+
+		frontier = next
+	}
+}
+
+func (u *User) IsIsolated() bool {
+	return len(u.followers) == 0 && len(u.requests) == 0 &&
+		len(u.blocked) == 0 && len(u.muted) == 0 && !u.private
+}
+
+// userState is the saved state of a User, subscribers are
+// referred to by ID
+type userState struct {
+	Followers []string `json:"followers,omitempty"`
+	Requests  []string `json:"requests,omitempty"`
+	Blocked   []string `json:"blocked,omitempty"`
+	Muted     []string `json:"muted,omitempty"`
+	Private   bool     `json:"private,omitempty"`
+}
+
+func subscriberIDs(subscribers map[string]event.Subscriber) []string {
+	result := []string{}
+
+	for id := range subscribers {
+		result = append(result, id)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func setIDs(set map[string]bool) []string {
+	result := []string{}
+
+	for id := range set {
+		result = append(result, id)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Snapshot return u state encoded as JSON, the same for the same state
+func (u *User) Snapshot() ([]byte, error) {
+	return json.Marshal(&userState{
+		Followers: subscriberIDs(u.followers),
+		Requests:  subscriberIDs(u.requests),
+		Blocked:   setIDs(u.blocked),
+		Muted:     setIDs(u.muted),
+		Private:   u.private,
+	})
+}
+
+// Restore replace u state with one returned by Snapshot
+func (u *User) Restore(state []byte, resolve func(string) event.Subscriber) error {
+	s := &userState{}
+
+	if err := json.Unmarshal(state, s); err != nil {
+		return fmt.Errorf("Cannot restore user %v: %v", u.id, err)
+	}
+
+	u.Init()
+	u.private = s.Private
+
+	for _, id := range s.Followers {
+		u.followers[id] = resolve(id)
+	}
+
+	for _, id := range s.Requests {
+		u.requests[id] = resolve(id)
+	}
+
+	for _, id := range s.Blocked {
+		u.blocked[id] = true
+	}
+
+	for _, id := range s.Muted {
+		u.muted[id] = true
+	}
+
+	return nil
+}
+
 func (u *User) Init() {
 	u.followers = map[string]event.Subscriber{}
+	u.blocked = map[string]bool{}
+	u.muted = map[string]bool{}
+	u.requests = map[string]event.Subscriber{}
 }
 
 func NewUser(ID string) event.Subscriber {
 	u := &User{}
 
 	u.id = ID
+	u.rules = DefaultDeliveryRules
+	u.networkHops = DefaultNetworkHops
 	u.Init()
 
 	return u
 }
+
+// NewUserFactory return a factory creating users which deliver events
+// using DefaultDeliveryRules, replaced by rules for the types it defines,
+// and reach networkHops away with network delivery
+// (DefaultNetworkHops when 0)
+func NewUserFactory(rules event.DeliveryRules, networkHops int) event.SubscriberFactoryType {
+	merged := DefaultDeliveryRules.Merge(rules)
+
+	if networkHops == 0 {
+		networkHops = DefaultNetworkHops
+	}
+
+	return func(ID string) event.Subscriber {
+		u := NewUser(ID).(*User)
+		u.rules = merged
+		u.networkHops = networkHops
+
+		return u
+	}
+}
@@ -2,80 +2,309 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
+	"github.com/andreadipersio/efr/admin"
+	"github.com/andreadipersio/efr/config"
 	"github.com/andreadipersio/efr/event"
+	"github.com/andreadipersio/efr/event/deadletter"
 	"github.com/andreadipersio/efr/event/dispatcher"
 	"github.com/andreadipersio/efr/event/listener"
 	"github.com/andreadipersio/efr/event/subscription"
 	"github.com/andreadipersio/efr/example"
+	"github.com/andreadipersio/efr/tlsutil"
 )
 
 func main() {
-	var (
-		resequencerType = flag.String("resequencerType", "stream",
-			"Resequencer type, can be 'batch' or 'stream'")
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
 
-		resequencerCap = flag.Int("resequencerCapacity", 100,
-			"Resequencer capacity.")
-
-		maxProcs = flag.Int("maxProcs", 1,
-			"Max number of OS Thread that can run simultaneously")
-
-		eventSourcePort = flag.Int("eventSourcePort", 9090, "EventSource connection port")
-		subPort         = flag.Int("clientPort", 9099, "Clients will subscribe using to this port")
-
-		sequenceIndex = flag.Int("sequenceIndex", 0,
-			"Last know sequence number. Stream resequencer "+
-				"will start resequencing from sequenceIndex+1")
-	)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
 
-	flag.Parse()
+	if err != nil {
+		log.Fatalf("*** %v", err)
+	}
 
-	resequencerConfig := &listener.ResequencerConfig{
-		*resequencerType,
-		*resequencerCap,
-		*sequenceIndex,
+	if cfg.Path != "" {
+		log.Printf("Configuration loaded from %v", cfg.Path)
 	}
 
-	runtime.GOMAXPROCS(*maxProcs)
-	log.Printf("Maximum number of concurrent threads set to %v", *maxProcs)
+	runtime.GOMAXPROCS(cfg.MaxProcs)
+	log.Printf("Maximum number of concurrent threads set to %v", cfg.MaxProcs)
 
 	// Acknowledge event source disconnection
 	ctrlChan := make(chan interface{})
 
 	// Dispatcher will wait for ordered events on that channel
-	eventChan := make(chan event.Event)
+	eventChan := make(chan event.Event, cfg.Dispatcher.QueueSize)
 
 	// Client connections
 	subChan := make(chan *subscription.SubscriptionRequest)
 
-	subscriptionServer := subscription.New(*subPort, subChan)
+	subscriptionServer := subscription.New(cfg.Subscription.Addrs, subChan)
+	subscriptionServer.Socket = cfg.Subscription.Socket
+	subscriptionServer.HTTPAddr = cfg.Subscription.HTTPAddr
+	subscriptionServer.EventFactory = example.NewEvent
+
+	// Events created from client commands
+	commandChan := make(chan event.Event)
+
+	// Commands are numbered by the Sequencer, producers stamping
+	// their own sequence numbers could not know about them
+	if cfg.Listener.Sequencing == listener.SERVER_SEQUENCING {
+		subscriptionServer.CommandFactory = example.NewCommandEvent
+		subscriptionServer.CommandChan = commandChan
+	}
+
+	// Idle disconnected subscribers are evicted from memory
+	var eviction *dispatcher.EvictionPolicy
+
+	if c := cfg.Dispatcher.Eviction; c.Enabled() {
+		eviction = &dispatcher.EvictionPolicy{
+			Interval:  time.Duration(c.Interval),
+			OrphanTTL: time.Duration(c.OrphanTTL),
+			IdleTTL:   time.Duration(c.IdleTTL),
+		}
+
+		// a persistent directory save evicted subscribers to its own store
+		if c.StoreDir != "" && cfg.Dispatcher.StoreDir == "" {
+			store, err := dispatcher.NewFileStore(c.StoreDir)
+
+			if err != nil {
+				log.Fatalf("*** %v", err)
+			}
+
+			eviction.Store = store
+		}
+	}
+
+	subscriberFactory := example.NewUserFactory(cfg.Dispatcher.Delivery, cfg.Dispatcher.NetworkHops)
+
+	// Subscribers and follow relationships are kept in memory
+	// or, to survive restarts, saved on disk
+	directory := dispatcher.NewDirectory(subscriberFactory)
+
+	if cfg.Dispatcher.StoreDir != "" {
+		store, err := dispatcher.NewFileStore(cfg.Dispatcher.StoreDir)
+
+		if err != nil {
+			log.Fatalf("*** %v", err)
+		}
+
+		directory = dispatcher.NewPersistentDirectory(subscriberFactory, store, cfg.Dispatcher.CacheSize)
+	}
 
-	dispatcher := dispatcher.New(
+	dispatcher := dispatcher.NewWithDirectory(
 		eventChan,
 		subChan,
 		ctrlChan,
-		example.NewUser,
+		subscriberFactory,
+		directory,
 	)
 
+	dispatcher.Eviction = eviction
+	dispatcher.EventTypes = example.EventTypes
+
+	// Number events on arrival instead of resequencing them,
+	// producers can then leave the sequence empty
+	var sequencer *listener.Sequencer
+	eventFactory := example.NewEvent
+
+	if cfg.Listener.Sequencing == listener.SERVER_SEQUENCING {
+		sequencer, err = listener.NewSequencer(cfg.Listener.SequenceFile, cfg.Listener.SequenceBlockSize)
+
+		if err != nil {
+			log.Fatalf("*** %v", err)
+		}
+
+		eventFactory = example.NewUnsequencedEvent
+	}
+
 	listener := listener.New(
-		*eventSourcePort,
+		cfg.Listener.Addrs,
 		eventChan,
 		ctrlChan,
-		resequencerConfig,
-		example.NewEvent,
+		&cfg.Resequencer,
+		eventFactory,
 	)
 
+	listener.Socket = cfg.Listener.Socket
+
+	if sequencer != nil {
+		listener.Sequencer = sequencer
+
+		// client commands share numbering with producer events
+		go listener.ListenCommands(commandChan)
+	}
+
+	// certificates which have to be reloaded with configuration
+	certificates := []*tlsutil.Certificates{}
+
+	enableTLS := func(tlsConfig *tlsutil.Config) *tls.Config {
+		if !tlsConfig.Enabled() {
+			return nil
+		}
+
+		c, err := tlsutil.New(tlsConfig)
+
+		if err != nil {
+			log.Fatalf("*** %v", err)
+		}
+
+		certificates = append(certificates, c)
+
+		return c.TLSConfig()
+	}
+
+	if path := cfg.Subscription.AuthSecretFile; path != "" {
+		secret, err := subscription.ReadSecretFile(path)
+
+		if err != nil {
+			log.Fatalf("*** Cannot read client auth secret: %v", err)
+		}
+
+		subscriptionServer.Authenticator = &subscription.HMACAuthenticator{Secret: secret}
+	}
+
+	if cfg.Listener.Policy != nil {
+		listener.Policy = cfg.Listener.Policy
+
+		if path := cfg.Listener.QuarantineFile; path != "" {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+			if err != nil {
+				log.Fatalf("*** Cannot open quarantine file: %v", err)
+			}
+
+			defer f.Close()
+
+			listener.Quarantine = f
+		}
+	}
+
+	// Events rejected by listener, resequencer and dispatcher
+	deadLetters := deadletter.New(cfg.DeadLetter.Size, nil)
+
+	if path := cfg.DeadLetter.File; path != "" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+		if err != nil {
+			log.Fatalf("*** Cannot open dead letter file: %v", err)
+		}
+
+		defer f.Close()
+
+		deadLetters.File = f
+	}
+
+	listener.DeadLetter = deadLetters
+
+	dispatcher.DeadLetter = func(e event.Event, err error) {
+		deadLetters.Add(deadletter.DISPATCHER_STAGE, "", e.String(), err)
+	}
+
+	listener.TLSConfig = enableTLS(&cfg.Listener.TLS)
+	subscriptionServer.TLSConfig = enableTLS(&cfg.Subscription.TLS)
+
+	reloader := config.NewReloader(cfg, os.Args[1:], os.LookupEnv, func(c *config.Config) {
+		runtime.GOMAXPROCS(c.MaxProcs)
+		listener.SetResequencerConfig(&c.Resequencer)
+
+		for _, certs := range certificates {
+			if err := certs.Reload(); err != nil {
+				log.Printf("*** Cannot reload certificates: %v", err)
+			}
+		}
+	})
+
+	// Reload configuration on SIGHUP
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			result, err := reloader.Reload()
+
+			if err != nil {
+				log.Printf("*** Configuration reload failed: %v", err)
+				continue
+			}
+
+			log.Printf("  = Configuration reloaded: applied %v, restart required %v",
+				result.Applied, result.RestartRequired)
+		}
+	}()
+
+	if cfg.Admin.Addr != "" {
+		adminServer := admin.New(cfg.Admin.Addr, reloader)
+
+		if path := cfg.Admin.TokenFile; path != "" {
+			token, err := subscription.ReadSecretFile(path)
+
+			if err != nil {
+				log.Fatalf("*** Cannot read admin token: %v", err)
+			}
+
+			adminServer.Token = string(token)
+		}
+
+		adminServer.HandleGraph(func(subscriberID string) interface{} {
+			return dispatcher.Graph(subscriberID)
+		})
+
+		// re-injected events are numbered as client commands are, those
+		// sent by an EventSource are checked against its policy again
+		adminServer.HandleDeadLetter(deadLetters, func(source, payload string) error {
+			if sequencer == nil {
+				return fmt.Errorf("Re-injection requires server sequencing")
+			}
+
+			e, err := example.NewUnsequencedEvent(payload)
+
+			if err != nil {
+				return err
+			}
+
+			if source != "" && cfg.Listener.Policy != nil {
+				if err := cfg.Listener.Policy.Authorize(source, e); err != nil {
+					return err
+				}
+			}
+
+			commandChan <- e
+
+			return nil
+		})
+
+		go adminServer.Listen()
+	}
+
 	// Listen for event source connection.
 	// Provide resequenceing of events
 	go listener.Listen()
 
+	// Accept events posted by producers
+	if cfg.Listener.HTTPAddr != "" {
+		listener.HTTPAddr = cfg.Listener.HTTPAddr
+		go listener.ListenHTTP()
+	}
+
 	// Listen for new client connection
 	go subscriptionServer.Listen()
 
+	if subscriptionServer.HTTPAddr != "" {
+		go subscriptionServer.ListenHTTP()
+	}
+
 	// Dispatch event between connected client
 	go dispatcher.Dispatch()
 
@@ -0,0 +1,302 @@
+// netutil package create listeners from address strings:
+//     :9090, localhost:9090, tcp://localhost:9090  TCP
+//     [::1]:9090, tcp://[::1]:9090                 TCP over IPv6
+//     unix:///run/efr/events.sock                  Unix domain socket
+// A server can listen on several addresses, given as an AddrList.
+// Unix domain sockets left behind by a previous run are removed,
+// and their file mode and ownership can be set with SocketOptions.
+package netutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	tcpScheme  = "tcp://"
+	unixScheme = "unix://"
+)
+
+// SocketOptions apply to Unix domain sockets only
+type SocketOptions struct {
+	// Octal file mode, e.g. "0660"
+	Mode string `json:"mode"`
+
+	// User and group, as names or numeric ids, owning the socket
+	Owner string `json:"owner"`
+	Group string `json:"group"`
+}
+
+// Validate return an error if options cannot be applied
+func (o *SocketOptions) Validate() error {
+	if o.Mode != "" {
+		if _, err := strconv.ParseUint(o.Mode, 8, 32); err != nil {
+			return fmt.Errorf("invalid socket mode %q, should be octal (e.g. 0660)", o.Mode)
+		}
+	}
+
+	return nil
+}
+
+// AddrList is a list of addresses, written as a comma separated
+// string on command line and as a string or an array in JSON
+type AddrList []string
+
+// String implement flag.Value
+func (l *AddrList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+// Set implement flag.Value, replacing l with addresses in value
+func (l *AddrList) Set(value string) error {
+	addrs := AddrList{}
+
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	*l = addrs
+
+	return nil
+}
+
+// UnmarshalJSON accept both "addr1,addr2" and ["addr1", "addr2"]
+func (l *AddrList) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err == nil {
+		return l.Set(value)
+	}
+
+	addrs := []string{}
+
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return fmt.Errorf("address list should be a string or an array of strings")
+	}
+
+	*l = addrs
+
+	return nil
+}
+
+// ParseAddr return network and address described by addr
+func ParseAddr(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, unixScheme):
+		path := strings.TrimPrefix(addr, unixScheme)
+
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in %q", addr)
+		}
+
+		return "unix", path, nil
+	case strings.HasPrefix(addr, tcpScheme):
+		addr = strings.TrimPrefix(addr, tcpScheme)
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("unsupported address scheme in %q", addr)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+
+	// IPv6 literals must be enclosed in brackets, which SplitHostPort
+	// strips; a zone (fe80::1%eth0) is allowed
+	if strings.Contains(host, ":") && net.ParseIP(strings.SplitN(host, "%", 2)[0]) == nil {
+		return "", "", fmt.Errorf("invalid IPv6 address %q", host)
+	}
+
+	return "tcp", addr, nil
+}
+
+// IsLocal report whenever addr can only be reached from this host:
+// Unix domain sockets and loopback addresses, localhost included
+func IsLocal(addr string) bool {
+	network, address, err := ParseAddr(addr)
+
+	if err != nil {
+		return false
+	}
+
+	if network == "unix" {
+		return true
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen announce on addr. For Unix domain sockets, a stale socket file
+// is removed first and opts are applied once listening.
+func Listen(addr string, opts *SocketOptions) (net.Listener, error) {
+	network, address, err := ParseAddr(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen(network, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" && opts != nil {
+		if err := applySocketOptions(address, opts); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
+
+// ListenAll announce on every address in addrs. Either all listeners
+// are returned, or none when one of them cannot be created.
+func ListenAll(addrs []string, opts *SocketOptions) ([]net.Listener, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen on")
+	}
+
+	listeners := []net.Listener{}
+
+	for _, addr := range addrs {
+		ln, err := Listen(addr, opts)
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("Cannot listen on %v: %v", addr, err)
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
+
+// removeStaleSocket remove the socket at path if nobody is listening on it
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%v is in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+func applySocketOptions(path string, opts *SocketOptions) error {
+	if opts.Mode != "" {
+		mode, err := strconv.ParseUint(opts.Mode, 8, 32)
+
+		if err != nil {
+			return fmt.Errorf("invalid socket mode %q", opts.Mode)
+		}
+
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Owner == "" && opts.Group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+
+	if opts.Owner != "" {
+		id, err := lookupID(opts.Owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return u.Uid, nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("unknown socket owner %q: %v", opts.Owner, err)
+		}
+
+		uid = id
+	}
+
+	if opts.Group != "" {
+		id, err := lookupID(opts.Group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+
+			if err != nil {
+				return "", err
+			}
+
+			return g.Gid, nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("unknown socket group %q: %v", opts.Group, err)
+		}
+
+		gid = id
+	}
+
+	return os.Lchown(path, uid, gid)
+}
+
+// lookupID return nameOrID as a number, resolving it with lookup
+// when it is a name
+func lookupID(nameOrID string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(nameOrID); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(nameOrID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,173 @@
+package netutil_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreadipersio/efr/netutil"
+)
+
+func TestParseAddr(t *testing.T) {
+	type testDataType struct {
+		addr             string
+		network, address string
+		isValid          bool
+	}
+
+	testData := []testDataType{
+		testDataType{":9090", "tcp", ":9090", true},
+		testDataType{"localhost:9090", "tcp", "localhost:9090", true},
+		testDataType{"tcp://127.0.0.1:9090", "tcp", "127.0.0.1:9090", true},
+		testDataType{"unix:///run/efr/events.sock", "unix", "/run/efr/events.sock", true},
+		testDataType{"[::1]:9090", "tcp", "[::1]:9090", true},
+		testDataType{"tcp://[::]:9090", "tcp", "[::]:9090", true},
+		testDataType{"[fe80::1%eth0]:9090", "tcp", "[fe80::1%eth0]:9090", true},
+
+		testDataType{"9090", "", "", false},
+		testDataType{"unix://", "", "", false},
+		testDataType{"udp://:9090", "", "", false},
+		testDataType{"::1:9090", "", "", false},
+		testDataType{"[not:an:ip]:9090", "", "", false},
+	}
+
+	for _, td := range testData {
+		network, address, err := netutil.ParseAddr(td.addr)
+
+		if !td.isValid {
+			if err == nil {
+				t.Fatalf("Parsing %v should fail!", td.addr)
+			}
+
+			continue
+		}
+
+		if err != nil || network != td.network || address != td.address {
+			t.Fatalf("Parsing %v: expected %v %v, got %v %v (%v)",
+				td.addr, td.network, td.address, network, address, err)
+		}
+	}
+}
+
+func TestIsLocal(t *testing.T) {
+	for addr, local := range map[string]bool{
+		"unix:///run/efr/admin.sock": true,
+		"localhost:9100":             true,
+		"tcp://127.0.0.1:9100":       true,
+		"[::1]:9100":                 true,
+		":9100":                      false,
+		"0.0.0.0:9100":               false,
+		"[::]:9100":                  false,
+		"10.0.0.1:9100":              false,
+		"9100":                       false,
+	} {
+		if l := netutil.IsLocal(addr); l != local {
+			t.Fatalf("Expected %v to be local %v, got %v", addr, local, l)
+		}
+	}
+}
+
+func TestAddrList(t *testing.T) {
+	var l netutil.AddrList
+
+	if err := l.Set(" localhost:9090, [::1]:9090,,"); err != nil || len(l) != 2 || l[1] != "[::1]:9090" {
+		t.Fatalf("Unexpected address list %v (%v)", l, err)
+	}
+
+	if err := json.Unmarshal([]byte(`[":9090", "unix:///run/efr.sock"]`), &l); err != nil || len(l) != 2 {
+		t.Fatalf("Cannot decode address array: %v %v", l, err)
+	}
+
+	if err := json.Unmarshal([]byte(`":9090,:9091"`), &l); err != nil || l.String() != ":9090,:9091" {
+		t.Fatalf("Cannot decode address string: %v %v", l, err)
+	}
+
+	if err := json.Unmarshal([]byte(`9090`), &l); err == nil {
+		t.Fatalf("Decoding a number should fail")
+	}
+}
+
+// TestListenAll prove that listeners are opened on every address,
+// or on none when one of them fails
+func TestListenAll(t *testing.T) {
+	listeners, err := netutil.ListenAll([]string{"127.0.0.1:0", "127.0.0.1:0"}, nil)
+
+	if err != nil || len(listeners) != 2 {
+		t.Fatalf("Expected 2 listeners, got %v (%v)", len(listeners), err)
+	}
+
+	busy := listeners[1].Addr().String()
+	listeners[0].Close()
+
+	// first address is free again, second is in use
+	free := listeners[0].Addr().String()
+
+	if _, err := netutil.ListenAll([]string{free, busy}, nil); err == nil {
+		t.Fatalf("Listening on an address in use should fail")
+	}
+
+	ln, err := net.Listen("tcp", free)
+
+	if err != nil {
+		t.Fatalf("Listener on %v should have been closed: %v", free, err)
+	}
+
+	ln.Close()
+	listeners[1].Close()
+}
+
+// TestUnixSocket prove that stale sockets are removed, sockets in use
+// are not, and socket mode is applied
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "efr.sock")
+	addr := "unix://" + path
+
+	// leave a stale socket behind
+	stale, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatalf("Cannot create socket: %v", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := netutil.Listen(addr, &netutil.SocketOptions{Mode: "0600"})
+
+	if err != nil {
+		t.Fatalf("Stale socket should be replaced: %v", err)
+	}
+
+	defer ln.Close()
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatalf("Cannot stat socket: %v", err)
+	}
+
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("Expected socket mode 0600, got %o", mode)
+	}
+
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	if _, err := netutil.Listen(addr, nil); err == nil {
+		t.Fatalf("Socket in use should not be replaced")
+	}
+
+	// regular files are never removed
+	file := filepath.Join(t.TempDir(), "file")
+	ioutil.WriteFile(file, []byte{}, 0600)
+
+	if _, err := netutil.Listen("unix://"+file, nil); err == nil {
+		t.Fatalf("Regular file should not be replaced")
+	}
+}
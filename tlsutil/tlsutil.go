@@ -0,0 +1,129 @@
+// tlsutil package provide TLS server configuration whose certificates
+// can be reloaded from disk without restarting servers using it.
+// Optionally clients are required to present a certificate signed
+// by one of the configured CAs (mutual TLS).
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+)
+
+type Config struct {
+	// PEM encoded certificate and private key
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+
+	// PEM encoded CA certificates used to verify client certificates.
+	// When set, clients must present a valid certificate.
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+// Enabled return whenever TLS has been configured
+func (c *Config) Enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientCAFile != ""
+}
+
+// Validate return an error if c is incomplete
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if c.CertFile == "" || c.KeyFile == "" {
+		return fmt.Errorf("both certificate and key file are required to enable TLS")
+	}
+
+	return nil
+}
+
+// Certificates hold certificates loaded from files described by Config
+type Certificates struct {
+	Config *Config
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Reload read certificates from disk again.
+// On error, previously loaded certificates are kept.
+func (c *Certificates) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.Config.CertFile, c.Config.KeyFile)
+
+	if err != nil {
+		return fmt.Errorf("Cannot load certificate: %v", err)
+	}
+
+	var clientCAs *x509.CertPool
+
+	if c.Config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.Config.ClientCAFile)
+
+		if err != nil {
+			return fmt.Errorf("Cannot read client CA file: %v", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificate found in client CA file %v", c.Config.ClientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cert = &cert
+	c.clientCAs = clientCAs
+
+	return nil
+}
+
+// serverConfig return a TLS configuration using the certificates
+// currently loaded
+func (c *Certificates) serverConfig() *tls.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*c.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.clientCAs != nil {
+		config.ClientCAs = c.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config
+}
+
+// TLSConfig return a server TLS configuration which always use
+// the last loaded certificates for new connections
+func (c *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.serverConfig(), nil
+		},
+	}
+}
+
+// New load certificates described by config
+func New(config *Config) (*Certificates, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Certificates{Config: config}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,217 @@
+package tlsutil_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andreadipersio/efr/tlsutil"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert generate a certificate for commonName signed by parent,
+// or self-signed when parent is nil
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Cannot generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	signer, signerKey := template, key
+
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+
+	if err != nil {
+		t.Fatalf("Cannot create certificate: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCert{cert, key, der}
+}
+
+// write certificate and key in dir, return their paths
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+
+	if err != nil {
+		t.Fatalf("Cannot marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serve accept TLS connections on a random port, completing handshakes
+func serve(t *testing.T, config *tls.Config) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+
+	if err != nil {
+		t.Fatalf("Cannot listen: %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// peerCommonName connect to addr and return the common name
+// of the certificate presented by the server
+func peerCommonName(addr string, clientCert *tls.Certificate) (string, error) {
+	config := &tls.Config{InsecureSkipVerify: true}
+
+	if clientCert != nil {
+		config.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	conn, err := tls.Dial("tcp", addr, config)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	// with TLS 1.3 client certificate errors are reported on first read
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := conn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+// TestReload prove that reloaded certificates are used for
+// new connections
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile := newTestCert(t, "first", nil).write(t, dir)
+
+	certs, err := tlsutil.New(&tlsutil.Config{CertFile: certFile, KeyFile: keyFile})
+
+	if err != nil {
+		t.Fatalf("Cannot load certificates: %v", err)
+	}
+
+	addr := serve(t, certs.TLSConfig())
+
+	if cn, err := peerCommonName(addr, nil); err != nil || cn != "first" {
+		t.Fatalf("Expected certificate 'first', got '%v' (%v)", cn, err)
+	}
+
+	newTestCert(t, "second", nil).write(t, dir)
+
+	if err := certs.Reload(); err != nil {
+		t.Fatalf("Cannot reload certificates: %v", err)
+	}
+
+	if cn, err := peerCommonName(addr, nil); err != nil || cn != "second" {
+		t.Fatalf("Expected certificate 'second', got '%v' (%v)", cn, err)
+	}
+}
+
+// TestMutualTLS prove that when a client CA is configured,
+// only clients presenting a certificate signed by it are accepted
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, t.TempDir())
+
+	certFile, keyFile := newTestCert(t, "server", nil).write(t, dir)
+
+	certs, err := tlsutil.New(&tlsutil.Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+	})
+
+	if err != nil {
+		t.Fatalf("Cannot load certificates: %v", err)
+	}
+
+	addr := serve(t, certs.TLSConfig())
+
+	if _, err := peerCommonName(addr, nil); err == nil {
+		t.Fatalf("Client without certificate should be rejected")
+	}
+
+	untrusted := newTestCert(t, "untrusted", nil).tlsCertificate()
+
+	if _, err := peerCommonName(addr, &untrusted); err == nil {
+		t.Fatalf("Client with untrusted certificate should be rejected")
+	}
+
+	trusted := newTestCert(t, "producer", ca).tlsCertificate()
+
+	if _, err := peerCommonName(addr, &trusted); err != nil {
+		t.Fatalf("Client with trusted certificate should be accepted: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (&tlsutil.Config{CertFile: "cert.pem"}).Validate(); err == nil {
+		t.Fatalf("Expected error when key file is missing")
+	}
+
+	if err := (&tlsutil.Config{}).Validate(); err != nil {
+		t.Fatalf("Disabled TLS should be valid: %v", err)
+	}
+}